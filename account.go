@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const ACCOUNT_AUDIT_EVENT_LIMIT = 50
+
+type AccountTemplate struct {
+	PageTemplate
+	//users created through oidc confirm deletion with their username instead
+	HasPassword bool
+	Events []AuditEvent
+}
+
+func handleAccountGet(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		db_user, err := store.GetUser(r.Context(), username)
+
+		if err != nil {
+			http.Error(w, "error retrieving account", http.StatusInternalServerError)
+			return
+		}
+
+		events, err := store.GetAuditEvents(r.Context(), &AuditFilter{
+			username: username,
+			limit: ACCOUNT_AUDIT_EVENT_LIMIT,
+		})
+
+		if err != nil {
+			http.Error(w, "error retrieving account activity", http.StatusInternalServerError)
+			return
+		}
+
+		data := AccountTemplate{
+			PageTemplate: PageTemplate{
+				Username: username,
+				IsAdmin: db_user.is_admin,
+				CsrfToken: csrfTokenFromContext(r.Context()),
+			},
+			HasPassword: db_user.password != NO_PASSWORD_PARAMS,
+			Events: events,
+		}
+
+		writeTemplate(w, r, "account.html", data)
+	}
+}
+
+func handleAccountExport(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		export, err := store.GetAccountExport(r.Context(), username)
+
+		if err != nil {
+			http.Error(w, "error exporting account", http.StatusInternalServerError)
+			return
+		}
+
+		body, err := json.MarshalIndent(export, "", "  ")
+
+		if err != nil {
+			requestLogger(r).Error(
+				"error marshalling account export",
+				"username", username,
+				"err", err.Error(),
+				"response_code", http.StatusInternalServerError,
+			)
+
+			http.Error(w, "error exporting account", http.StatusInternalServerError)
+			return
+		}
+
+		filename := fmt.Sprintf("goal-tracker-%s.json", time.Now().Format(time.DateOnly))
+
+		requestLogger(r).Info(
+			"exported account data",
+			"username", username,
+			"response_code", http.StatusOK,
+		)
+
+		recordAuditEvent(store, r, AUDIT_ACCOUNT_EXPORT, username, AUDIT_SUCCESS, "")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.Write(body)
+	}
+}
+
+//checks the user re-entered their password, or their username
+//if they have no password, before a destructive account change
+func confirmAccountOwner(store Store, username string, r *http.Request) (err_msg string, status_code int) {
+	db_user, err := store.GetUser(r.Context(), username)
+
+	if err != nil {
+		return "Error validating user", http.StatusInternalServerError
+	}
+
+	if db_user.password == NO_PASSWORD_PARAMS {
+		if r.PostForm.Get("confirm_username") != username {
+			return "Username does not match", http.StatusUnauthorized
+		}
+
+		return "", 0
+	}
+
+	match, err := comparePasswordWithHash(r.PostForm.Get("password"), db_user.password)
+
+	if err != nil {
+		requestLogger(r).Error(
+			"error comparing passwords",
+			"err", err.Error(),
+			"response_code", http.StatusInternalServerError,
+		)
+
+		return "Error validating user", http.StatusInternalServerError
+	}
+
+	if !match {
+		return "Incorrect password", http.StatusUnauthorized
+	}
+
+	return "", 0
+}
+
+func handleAccountDeletePost(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+
+		if err != nil {
+			http.Error(w, "malformed form request", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		err_msg, status_code := confirmAccountOwner(store, username, r)
+
+		if status_code != 0 {
+			requestLogger(r).Info(
+				"account deletion not confirmed",
+				"username", username,
+				"response_code", status_code,
+			)
+
+			recordAuditEvent(store, r, AUDIT_ACCOUNT_DELETE, username, AUDIT_FAILURE, err_msg)
+			http.Error(w, err_msg, status_code)
+			return
+		}
+
+		err = store.DeleteUser(r.Context(), username)
+
+		if err != nil {
+			http.Error(w, "error deleting account", http.StatusInternalServerError)
+			return
+		}
+
+		requestLogger(r).Info(
+			"user deleted their account",
+			"username", username,
+			"response_code", http.StatusSeeOther,
+		)
+
+		//recorded after deletion so it isn't anonymised with the rest of the user's events
+		recordAuditEvent(store, r, AUDIT_ACCOUNT_DELETE, DELETED_USERNAME, AUDIT_SUCCESS, "")
+
+		http.SetCookie(w, expiredSessionCookie())
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+}
+
+func handleAccountPasswordPost(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+
+		if err != nil {
+			http.Error(w, "malformed form request", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		err_msg, status_code := confirmAccountOwner(store, username, r)
+
+		if status_code != 0 {
+			recordAuditEvent(store, r, AUDIT_PASSWORD_CHANGE, username, AUDIT_FAILURE, err_msg)
+			http.Error(w, err_msg, status_code)
+			return
+		}
+
+		new_user := User{ username: username, password: r.PostForm.Get("new_password") }
+		valid_user := validateUserConstraints(&new_user)
+
+		if valid_user != "" {
+			http.Error(w, valid_user, http.StatusUnprocessableEntity)
+			return
+		}
+
+		err = store.UpdateUserPassword(r.Context(), username, hashPassword(new_user.password))
+
+		if err != nil {
+			http.Error(w, "error changing password", http.StatusInternalServerError)
+			return
+		}
+
+		requestLogger(r).Info(
+			"user changed their password",
+			"username", username,
+			"response_code", http.StatusSeeOther,
+		)
+
+		recordAuditEvent(store, r, AUDIT_PASSWORD_CHANGE, username, AUDIT_SUCCESS, "")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	}
+}
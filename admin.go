@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+)
+
+type AdminTemplate struct {
+	PageTemplate
+	Users []UserSummary
+	Invites []InviteCodeSummary
+	//set only in the response that creates the code
+	NewInviteCode string
+}
+
+//must be layered inside authorisationMiddleware, which sets is_admin on the context
+func adminMiddleware(next http.Handler) http.Handler {
+	handler_func := func(w http.ResponseWriter, r *http.Request) {
+		is_admin, _ := r.Context().Value("is_admin").(bool)
+
+		if !is_admin {
+			requestLogger(r).Info(
+				"non admin attempted to access admin route",
+				"username", r.Context().Value("username"),
+				"path", r.URL.Path,
+				"response_code", http.StatusForbidden,
+			)
+
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(handler_func)
+}
+
+func writeAdminPage(w http.ResponseWriter, r *http.Request, store Store, new_invite_code string) {
+	users, err := store.GetUserSummaries(r.Context())
+
+	if err != nil {
+		http.Error(w, "error retrieving users", http.StatusInternalServerError)
+		return
+	}
+
+	invites, err := store.GetInviteCodes(r.Context())
+
+	if err != nil {
+		http.Error(w, "error retrieving invite codes", http.StatusInternalServerError)
+		return
+	}
+
+	data := AdminTemplate{
+		PageTemplate: PageTemplate{
+			Username: r.Context().Value("username").(string),
+			IsAdmin: true,
+			CsrfToken: csrfTokenFromContext(r.Context()),
+		},
+		Users: users,
+		Invites: invites,
+		NewInviteCode: new_invite_code,
+	}
+
+	writeTemplate(w, r, "admin.html", data)
+}
+
+func handleAdminGet(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeAdminPage(w, r, store, "")
+	}
+}
+
+func handleAdminUserAction(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := r.Context().Value("username").(string)
+		username := r.PathValue("username")
+		action := r.PathValue("action")
+
+		//stops an admin locking themselves out
+		if username == admin && (action == "disable" || action == "delete") {
+			http.Error(w, "cannot " + action + " your own account", http.StatusUnprocessableEntity)
+			return
+		}
+
+		var err error
+
+		switch action {
+		case "disable":
+			err = store.SetUserDisabled(r.Context(), username, true)
+		case "enable":
+			err = store.SetUserDisabled(r.Context(), username, false)
+		case "logout":
+			err = store.DeleteUserSessions(r.Context(), username)
+		case "delete":
+			err = store.DeleteUser(r.Context(), username)
+		default:
+			http.Error(w, "unknown action", http.StatusNotFound)
+			return
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "error updating user", http.StatusInternalServerError)
+			return
+		}
+
+		requestLogger(r).Info(
+			"admin action on user",
+			"admin", admin,
+			"username", username,
+			"action", action,
+			"response_code", http.StatusSeeOther,
+		)
+
+		recordAuditEvent(store, r, AUDIT_ADMIN_ACTION, admin, AUDIT_SUCCESS, action + " " + username)
+
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const AUDIT_LOGIN = "login"
+const AUDIT_LOGOUT = "logout"
+const AUDIT_REGISTER = "register"
+const AUDIT_PASSWORD_CHANGE = "password_change"
+const AUDIT_INVITE_CREATED = "invite_created"
+const AUDIT_INVITE_REVOKED = "invite_revoked"
+const AUDIT_ACCOUNT_EXPORT = "account_export"
+const AUDIT_ACCOUNT_DELETE = "account_delete"
+const AUDIT_ADMIN_ACTION = "admin_action"
+
+const AUDIT_SUCCESS = "success"
+const AUDIT_FAILURE = "failure"
+
+const AUDIT_DEFAULT_LIMIT = 100
+const AUDIT_MAX_LIMIT = 1000
+//the widths of the AuditEvent columns
+const AUDIT_USERNAME_MAX_LEN = USERNAME_MAX_LEN
+const AUDIT_USER_AGENT_MAX_LEN = 512
+const AUDIT_DETAIL_MAX_LEN = 255
+
+type AuditEvent struct {
+	Id int64 `json:"id"`
+	Created time.Time `json:"created"`
+	EventType string `json:"event_type"`
+	Username string `json:"username"`
+	Ip string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Outcome string `json:"outcome"`
+	Detail string `json:"detail"`
+}
+
+type AuditFilter struct {
+	username string
+	event_type string
+	outcome string
+	since *time.Time
+	until *time.Time
+	limit int
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+//cuts str to max_len characters, which is how VARCHAR(n) counts, without
+//splitting a multi-byte character. invalid utf8, which postgres rejects,
+//is replaced first
+func truncate(str string, max_len int) string {
+	str = strings.ToValidUTF8(str, string(utf8.RuneError))
+
+	if utf8.RuneCountInString(str) > max_len {
+		return string([]rune(str)[:max_len])
+	}
+
+	return str
+}
+
+//records an event for the request. failing to record is logged
+//but never fails the request that triggered it. login events are
+//also counted in the logins_total metric
+func recordAuditEvent(store Store, r *http.Request, event_type string, username string, outcome string, detail string) {
+	if event_type == AUDIT_LOGIN {
+		logins_total.WithLabelValues(outcome).Inc()
+	}
+
+	event := AuditEvent{
+		EventType: event_type,
+		//failed logins record the username as submitted, which may be any length
+		Username: truncate(username, AUDIT_USERNAME_MAX_LEN),
+		Ip: clientIP(r),
+		UserAgent: truncate(r.UserAgent(), AUDIT_USER_AGENT_MAX_LEN),
+		Outcome: outcome,
+		Detail: truncate(detail, AUDIT_DETAIL_MAX_LEN),
+	}
+
+	//still recorded if the client disconnects mid request
+	store.InsertAuditEvent(context.WithoutCancel(r.Context()), &event)
+}
+
+func parseAuditFilter(params url.Values) (*AuditFilter, error) {
+	filter := AuditFilter{
+		username: params.Get("username"),
+		event_type: params.Get("event_type"),
+		outcome: params.Get("outcome"),
+		limit: AUDIT_DEFAULT_LIMIT,
+	}
+
+	if filter.outcome != "" && filter.outcome != AUDIT_SUCCESS && filter.outcome != AUDIT_FAILURE {
+		return nil, errors.New("outcome must be success or failure")
+	}
+
+	if since_str := params.Get("since"); since_str != "" {
+		since, err := time.Parse(time.RFC3339, since_str)
+
+		if err != nil {
+			return nil, errors.New("Malformed since param, expected RFC3339")
+		}
+
+		filter.since = &since
+	}
+
+	if until_str := params.Get("until"); until_str != "" {
+		until, err := time.Parse(time.RFC3339, until_str)
+
+		if err != nil {
+			return nil, errors.New("Malformed until param, expected RFC3339")
+		}
+
+		filter.until = &until
+	}
+
+	if limit_str := params.Get("limit"); limit_str != "" {
+		limit, err := strconv.Atoi(limit_str)
+
+		if err != nil || limit < 1 || limit > AUDIT_MAX_LIMIT {
+			return nil, fmt.Errorf("limit must be between 1 and %d", AUDIT_MAX_LIMIT)
+		}
+
+		filter.limit = limit
+	}
+
+	return &filter, nil
+}
+
+//returns the query string and the params
+func constructAuditQuery(filter *AuditFilter) (string, []any) {
+	var query strings.Builder
+	params := []any{}
+	conditions := []string{}
+
+	addCondition := func(condition string, param any) {
+		params = append(params, param)
+		conditions = append(conditions, fmt.Sprintf(condition, len(params)))
+	}
+
+	if filter.username != "" {
+		addCondition("username = $%d", filter.username)
+	}
+	if filter.event_type != "" {
+		addCondition("event_type = $%d", filter.event_type)
+	}
+	if filter.outcome != "" {
+		addCondition("outcome = $%d", filter.outcome)
+	}
+	if filter.since != nil {
+		addCondition("created_datetime >= $%d", *filter.since)
+	}
+	if filter.until != nil {
+		addCondition("created_datetime < $%d", *filter.until)
+	}
+
+	query.WriteString("SELECT id, created_datetime, event_type, username, ip, user_agent, outcome, detail FROM AuditEvent")
+
+	if len(conditions) != 0 {
+		query.WriteString(" WHERE ")
+		query.WriteString(strings.Join(conditions, " AND "))
+	}
+
+	params = append(params, filter.limit)
+	query.WriteString(fmt.Sprintf(" ORDER BY created_datetime DESC, id DESC LIMIT $%d", len(params)))
+
+	return query.String(), params
+}
+
+func handleAdminAuditGet(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseAuditFilter(r.URL.Query())
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		events, err := store.GetAuditEvents(r.Context(), filter)
+
+		if err != nil {
+			http.Error(w, "error retrieving audit events", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	cases := []struct {
+		str string
+		max_len int
+		expected string
+	}{
+		{ "short", 10, "short" },
+		{ "exactly", 7, "exactly" },
+		{ "héllo wörld", 5, "héllo" },
+		{ "日本語テキスト", 3, "日本語" },
+		{ "bad \xff byte", 20, "bad \uFFFD byte" },
+	}
+
+	for _, c := range cases {
+		if got := truncate(c.str, c.max_len); got != c.expected {
+			t.Errorf("truncate(%q, %d): expected %q. got: %q", c.str, c.max_len, c.expected, got)
+		}
+	}
+}
+
+//an overlong username or multi-byte user agent must still be audited, or
+//failed logins could be hidden by sending one
+func TestRecordAuditEventFitsColumns(t *testing.T) {
+	stores := []struct {
+		name string
+		newStore func(t *testing.T) Store
+	}{
+		{ "memory", func(t *testing.T) Store { return newMemoryStore() } },
+		{ "sqlite", func(t *testing.T) Store { return openTestSqliteStore(t) } },
+		{ "postgres", func(t *testing.T) Store { return newPostgresStore(openTestDB(t)) } },
+	}
+
+	username := strings.Repeat("u", 200)
+	user_agent := strings.Repeat("ü", AUDIT_USER_AGENT_MAX_LEN + 10)
+	detail := strings.Repeat("错", AUDIT_DETAIL_MAX_LEN + 10)
+
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			s := store.newStore(t)
+
+			r := httptest.NewRequest(http.MethodPost, "/login", nil)
+			r.Header.Set("User-Agent", user_agent)
+
+			recordAuditEvent(s, r, AUDIT_LOGIN, username, AUDIT_FAILURE, detail)
+
+			since := time.Now().Add(-time.Minute)
+			events, err := s.GetAuditEvents(context.Background(), &AuditFilter{
+				event_type: AUDIT_LOGIN,
+				since: &since,
+				limit: AUDIT_DEFAULT_LIMIT,
+			})
+
+			if err != nil || len(events) != 1 {
+				t.Fatalf("expected the event to be stored. got: %+v %v", events, err)
+			}
+
+			event := events[0]
+
+			if event.Username != username[:AUDIT_USERNAME_MAX_LEN] {
+				t.Errorf("expected the username cut to %d characters. got: %d", AUDIT_USERNAME_MAX_LEN, len(event.Username))
+			}
+
+			if !utf8.ValidString(event.UserAgent) || utf8.RuneCountInString(event.UserAgent) != AUDIT_USER_AGENT_MAX_LEN {
+				t.Errorf("expected a valid user agent of %d characters. got: %q", AUDIT_USER_AGENT_MAX_LEN, event.UserAgent)
+			}
+
+			if !utf8.ValidString(event.Detail) || utf8.RuneCountInString(event.Detail) != AUDIT_DETAIL_MAX_LEN {
+				t.Errorf("expected a valid detail of %d characters. got: %q", AUDIT_DETAIL_MAX_LEN, event.Detail)
+			}
+		})
+	}
+}
+
+func TestConstructAuditQuery(t *testing.T) {
+	query, params := constructAuditQuery(&AuditFilter{ limit: 10 })
+
+	expected_query := "SELECT id, created_datetime, event_type, username, ip, user_agent, outcome, detail FROM AuditEvent ORDER BY created_datetime DESC, id DESC LIMIT $1"
+
+	if query != expected_query {
+		t.Errorf("query was not as expected. expected: %s, got %s", expected_query, query)
+	}
+
+	if len(params) != 1 || params[0] != 10 {
+		t.Errorf("params were not as expected. got: %v", params)
+	}
+
+	since := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	query, params = constructAuditQuery(&AuditFilter{
+		username: "user",
+		outcome: AUDIT_FAILURE,
+		since: &since,
+		limit: 5,
+	})
+
+	expected_query = "SELECT id, created_datetime, event_type, username, ip, user_agent, outcome, detail FROM AuditEvent WHERE username = $1 AND outcome = $2 AND created_datetime >= $3 ORDER BY created_datetime DESC, id DESC LIMIT $4"
+
+	if query != expected_query {
+		t.Errorf("query was not as expected. expected: %s, got %s", expected_query, query)
+	}
+
+	expected_params := []any{ "user", AUDIT_FAILURE, since, 5 }
+
+	if len(params) != len(expected_params) {
+		t.Fatalf("param count was not as expected. expected: %d, got: %d", len(expected_params), len(params))
+	}
+
+	for i, param := range params {
+		if param != expected_params[i] {
+			t.Errorf("difference in params at index %d. expected: %v, got %v", i, expected_params[i], param)
+		}
+	}
+}
+
+func TestParseAuditFilter(t *testing.T) {
+	filter, err := parseAuditFilter(url.Values{})
+
+	if err != nil {
+		t.Fatalf("empty filter should be valid. got: %s", err.Error())
+	}
+
+	if filter.limit != AUDIT_DEFAULT_LIMIT {
+		t.Errorf("expected default limit %d. got: %d", AUDIT_DEFAULT_LIMIT, filter.limit)
+	}
+
+	filter, err = parseAuditFilter(url.Values{
+		"username": { "user" },
+		"event_type": { AUDIT_LOGIN },
+		"since": { "2025-01-01T00:00:00Z" },
+		"limit": { "20" },
+	})
+
+	if err != nil {
+		t.Fatalf("filter should be valid. got: %s", err.Error())
+	}
+
+	if filter.username != "user" || filter.event_type != AUDIT_LOGIN || filter.since == nil || filter.limit != 20 {
+		t.Errorf("filter was not as expected. got: %+v", *filter)
+	}
+
+	invalid := []url.Values{
+		{ "outcome": { "maybe" } },
+		{ "since": { "2025-01-01" } },
+		{ "until": { "yesterday" } },
+		{ "limit": { "0" } },
+		{ "limit": { "100000" } },
+	}
+
+	for _, params := range invalid {
+		if _, err := parseAuditFilter(params); err == nil {
+			t.Errorf("expected %v to be invalid", params)
+		}
+	}
+}
@@ -1,14 +1,43 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"net/http"
 )
 
 const SESSION_ID_LEN_BYTE = 64
+const DEFAULT_SESSION_MAX_AGE = 60 * 60 * 24 * 7
+
+//cookie settings are read from the live config on every use
+//so they follow reloads
+func sessionMaxAge() int {
+	if max_age := liveConfig().Session.Max_age; max_age != 0 {
+		return int(max_age)
+	}
+
+	return DEFAULT_SESSION_MAX_AGE
+}
+
+func secureCookies() bool {
+	return !liveConfig().Session.Insecure_cookies
+}
+
+func newSessionCookie(session_id string) *http.Cookie {
+	return &http.Cookie{
+		Name: "session_id",
+		Value: session_id,
+		Path: "/",
+		MaxAge: sessionMaxAge(),
+		HttpOnly: true,
+		Secure: secureCookies(),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
 
 //returns the session id as a hex string.
 //therefore returned string's len will be 2 times that of byte_len
@@ -26,23 +55,31 @@ func generateSessionId(byte_len int) (string, error) {
 	return hex_session_id, nil
 }
 
-//returns empty string in case of bad auth token
-func VerifyUser(db *sql.DB, session_id string) (username string, err error) {
+//a cookie that tells the client to drop its session_id immediately
+func expiredSessionCookie() *http.Cookie {
+	cookie := newSessionCookie("")
+	cookie.MaxAge = -1
+
+	return cookie
+}
+
+//returns empty string in case of bad auth token or disabled user
+func VerifyUser(ctx context.Context, store Store, session_id string) (username string, is_admin bool, err error) {
 	hash := sha256.Sum256([]byte(session_id))
-	username, err = GetSessionId(db, hash)
+	username, is_admin, err = store.GetSessionId(ctx, hash)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return "", nil
+			return "", false, nil
 		} else {
-			return "", err
+			return "", false, err
 		}
 	}
 
-	return username, nil
+	return username, is_admin, nil
 }
 
-func CreateUserSessionId(db *sql.DB, username string) (string, error) {
+func CreateUserSessionId(ctx context.Context, store Store, username string) (string, error) {
 	session_id, err := generateSessionId(SESSION_ID_LEN_BYTE)
 
 	if err != nil {
@@ -50,7 +87,14 @@ func CreateUserSessionId(db *sql.DB, username string) (string, error) {
 	}
 
 	hash := sha256.Sum256([]byte(session_id))
-	UpsertSessionId(db, username, hash)
+	err = store.UpsertSessionId(ctx, username, hash)
+
+	if err != nil {
+		return "", err
+	}
+
+	//last login is informational so failing to record it doesn't fail the login
+	store.UpdateUserLastLogin(ctx, username)
 
 	return session_id, nil
 }
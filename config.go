@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 )
@@ -10,15 +11,37 @@ import (
 type Config struct {
 	Host string `json:"host"`
 	Port uint16 `json:"port"`
-	Db struct {
-		Host          string `json:"host"`
-		Port          uint16 `json:"port"`
-		Database_name string `json:"database_name"`
-		Username      string `json:"username"`
-		Password      string `json:"password"`
-	} `json:"db"`
+	//optional, serves https directly instead of behind a proxy
+	Tls TlsConfig `json:"tls"`
+	//optional, zero values fall back to the defaults in server.go
+	Server ServerConfig `json:"server"`
+	Metrics MetricsConfig `json:"metrics"`
+	Log LogConfig `json:"log"`
+	Tracing TracingConfig `json:"tracing"`
+	Db DbConfig `json:"db"`
+	Session struct {
+		//seconds until the session cookie expires
+		Max_age          uint32 `json:"max_age"`
+		//only meant for local development over plain http
+		Insecure_cookies bool   `json:"insecure_cookies"`
+	} `json:"session"`
+	Registration RegistrationConfig `json:"registration"`
+	//users granted admin at startup, used to bootstrap the first admin
+	Admin_usernames []string `json:"admin_usernames"`
+	Oidc_providers []OidcProviderConfig `json:"oidc_providers"`
+	//optional, zero values fall back to default_params
+	Argon2 struct {
+		Time        uint32 `json:"time"`
+		Memory      uint32 `json:"memory"`
+		Threads     uint8  `json:"threads"`
+		Key_len     uint32 `json:"key_len"`
+		Salt_len    uint16 `json:"salt_len"`
+		Pepper_file string `json:"pepper_file"`
+	} `json:"argon2"`
 }
 
+//the file is checked against config.json.schema before being unmarshalled,
+//so unknown keys and wrongly typed values are rejected here
 func parseConfig(path string) (*Config, error) {
 	slog.Info("attempting to open config file at " + path)
 	json_str, err := os.ReadFile(path)
@@ -28,7 +51,13 @@ func parseConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
-	slog.Debug("file successfully read into str\n" + string(json_str))
+	err = validateConfigJSON(json_str)
+
+	if err != nil {
+		err = fmt.Errorf("%s does not match config schema:\n%w", path, err)
+		slog.Error(err.Error())
+		return nil, err
+	}
 
 	var conf Config
 	err = json.Unmarshal(json_str, &conf)
@@ -38,44 +67,79 @@ func parseConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	//secrets are masked by Config.LogValue
 	slog.Info("config successfully parsed")
+	slog.Debug("parsed config", "config", conf)
 
 	return &conf, nil
 }
 
+//checks the final config, after env overrides, for values the schema
+//can't require because they may come from the environment instead.
+//every problem is reported rather than just the first
 func validateConfig(conf *Config) error {
+	violations := []error{}
+
+	addMissing := func(pointer string) {
+		violations = append(violations, schemaViolation{ pointer: pointer, message: "missing required value" })
+	}
+
 	if conf.Host == "" {
-		err := errors.New("config is missing host value")
-		slog.Error(err.Error())
-		return err
+		addMissing("/host")
 	}
 	if conf.Port == 0 {
-		err := errors.New("config is missing port value")
-		slog.Error(err.Error())
-		return err
-	}
-	if conf.Db.Host == "" {
-		err := errors.New("config is missing db.host value")
-		slog.Error(err.Error())
-		return err
+		addMissing("/port")
 	}
-	if conf.Db.Port == 0 {
-		err := errors.New("config is missing db.port value")
-		slog.Error(err.Error())
-		return err
+
+	violations = append(violations, validateDbConfig(&conf.Db)...)
+	violations = append(violations, validateTlsConfig(&conf.Tls)...)
+	violations = append(violations, validateLogConfig(&conf.Log)...)
+	violations = append(violations, validateTracingConfig(&conf.Tracing)...)
+
+	//session cookies must never be sent over plain http when serving https
+	if tlsEnabled(&conf.Tls) && conf.Session.Insecure_cookies {
+		violations = append(violations, schemaViolation{
+			pointer: "/session/insecure_cookies",
+			message: "cannot be set when tls is enabled",
+		})
 	}
-	if conf.Db.Database_name == "" {
-		err := errors.New("config is missing db.database_name value")
-		slog.Error(err.Error())
-		return err
+
+	err := validateRegistrationConfig(&conf.Registration)
+
+	if err != nil {
+		violations = append(violations, err)
 	}
-	if conf.Db.Username == "" {
-		err := errors.New("config is missing db.username value")
-		slog.Error(err.Error())
-		return err
+
+	provider_names := map[string]bool{}
+
+	for i, provider := range conf.Oidc_providers {
+		pointer := fmt.Sprintf("/oidc_providers/%d", i)
+
+		if provider.Name == "" {
+			addMissing(pointer + "/name")
+		}
+		if provider.Issuer == "" {
+			addMissing(pointer + "/issuer")
+		}
+		if provider.Client_id == "" {
+			addMissing(pointer + "/client_id")
+		}
+		if provider.Redirect_url == "" {
+			addMissing(pointer + "/redirect_url")
+		}
+
+		if provider.Name != "" && provider_names[provider.Name] {
+			violations = append(violations, schemaViolation{
+				pointer: pointer + "/name",
+				message: "duplicate provider name " + provider.Name,
+			})
+		}
+
+		provider_names[provider.Name] = true
 	}
-	if conf.Db.Password == "" {
-		err := errors.New("config is missing db.password value")
+
+	if len(violations) != 0 {
+		err := fmt.Errorf("config is invalid:\n%w", errors.Join(violations...))
 		slog.Error(err.Error())
 		return err
 	}
@@ -84,4 +148,3 @@ func validateConfig(conf *Config) error {
 
 	return nil
 }
-
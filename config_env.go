@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//Config is built up in layers, each overriding the last:
+//
+//  1. zero values, later filled with defaults where a field documents one
+//  2. the json config file. its path is taken from the --config flag,
+//     then GOALTRACKER_CONFIG, then config.json in the working directory
+//  3. GOALTRACKER_* environment variables, one per field
+//  4. GOALTRACKER_*_FILE environment variables, read from the named file
+//
+//env names are the json keys joined by underscores and upper cased, so
+//db.password is GOALTRACKER_DB_PASSWORD and its file variant is
+//GOALTRACKER_DB_PASSWORD_FILE. setting both for a field is an error.
+//list fields take comma separated values, except lists of objects
+//such as oidc_providers which take a json array.
+
+const ENV_PREFIX = "GOALTRACKER"
+const ENV_FILE_SUFFIX = "_FILE"
+const CONFIG_PATH_ENV = "GOALTRACKER_CONFIG"
+const DEFAULT_CONFIG_PATH = "config.json"
+
+type envLookup func(key string) (string, bool)
+
+//returns the config file path and whether it was explicitly chosen
+func resolveConfigPath(flag_path string, lookup envLookup) (string, bool) {
+	if flag_path != "" {
+		return flag_path, true
+	}
+
+	if env_path, ok := lookup(CONFIG_PATH_ENV); ok && env_path != "" {
+		return env_path, true
+	}
+
+	return DEFAULT_CONFIG_PATH, false
+}
+
+//reads KEY, or the contents of the file named by KEY_FILE
+func lookupEnvValue(key string, lookup envLookup) (value string, ok bool, err error) {
+	value, ok = lookup(key)
+	file_path, file_ok := lookup(key + ENV_FILE_SUFFIX)
+
+	if !file_ok {
+		return value, ok, nil
+	}
+
+	if ok {
+		return "", false, fmt.Errorf("both %s and %s%s are set", key, key, ENV_FILE_SUFFIX)
+	}
+
+	content, err := os.ReadFile(file_path)
+
+	if err != nil {
+		return "", false, fmt.Errorf("error reading %s%s: %s", key, ENV_FILE_SUFFIX, err.Error())
+	}
+
+	//files written by editors and secret mounts usually end in a newline
+	return strings.TrimRight(string(content), "\r\n"), true, nil
+}
+
+func applyEnvOverrides(conf *Config, lookup envLookup) error {
+	return applyEnvOverridesToStruct(reflect.ValueOf(conf).Elem(), ENV_PREFIX, lookup)
+}
+
+func applyEnvOverridesToStruct(value reflect.Value, prefix string, lookup envLookup) error {
+	value_type := value.Type()
+
+	for i := 0; i < value_type.NumField(); i++ {
+		field := value_type.Field(i)
+		json_name := strings.Split(field.Tag.Get("json"), ",")[0]
+
+		if json_name == "" || json_name == "-" {
+			continue
+		}
+
+		key := prefix + "_" + strings.ToUpper(json_name)
+		field_value := value.Field(i)
+
+		if field.Type.Kind() == reflect.Struct {
+			err := applyEnvOverridesToStruct(field_value, key, lookup)
+
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		env_value, ok, err := lookupEnvValue(key, lookup)
+
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		err = setFieldFromEnv(field_value, env_value)
+
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %s", key, err.Error())
+		}
+
+		//value is deliberately left out, it may be a secret
+		slog.Info("config value overridden from environment", "key", key)
+	}
+
+	return nil
+}
+
+func setFieldFromEnv(field reflect.Value, env_value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(env_value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(env_value)
+
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(env_value, 10, field.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(env_value, 10, field.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		field.SetUint(parsed)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.String {
+			items := []string{}
+
+			for _, item := range strings.Split(env_value, ",") {
+				item = strings.TrimSpace(item)
+
+				if item != "" {
+					items = append(items, item)
+				}
+			}
+
+			field.Set(reflect.ValueOf(items))
+			return nil
+		}
+
+		parsed := reflect.New(field.Type())
+		err := json.Unmarshal([]byte(env_value), parsed.Interface())
+
+		if err != nil {
+			return err
+		}
+
+		field.Set(parsed.Elem())
+	default:
+		return errors.New("unsupported config field type " + field.Type().String())
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+//top level config keys that are read from the live config on every use,
+//or applied by storeLiveConfig, and so can change on SIGHUP. anything
+//else is only read at startup
+var reloadable_config_keys = map[string]bool{
+	"log": true,
+	"session": true,
+	"registration": true,
+}
+
+var live_config atomic.Pointer[Config]
+
+//the config currently in effect. never nil, before a config is stored
+//the zero Config is returned so every field falls back to its default
+func liveConfig() *Config {
+	conf := live_config.Load()
+
+	if conf == nil {
+		return &Config{}
+	}
+
+	return conf
+}
+
+func storeLiveConfig(conf *Config) {
+	if conf.Session.Insecure_cookies {
+		slog.Warn("secure cookie attribute disabled, cookies will be sent over plain http")
+	}
+
+	applyLogConfig(&conf.Log)
+	live_config.Store(conf)
+}
+
+//appends the dotted json keys of every field that differs between old and new.
+//structs are compared field by field, everything else as a whole
+func diffConfigValues(old reflect.Value, new reflect.Value, prefix string, changed []string) []string {
+	value_type := old.Type()
+
+	for i := 0; i < value_type.NumField(); i++ {
+		field := value_type.Field(i)
+		json_name := strings.Split(field.Tag.Get("json"), ",")[0]
+
+		if json_name == "" || json_name == "-" {
+			continue
+		}
+
+		key := prefix + json_name
+
+		if field.Type.Kind() == reflect.Struct {
+			changed = diffConfigValues(old.Field(i), new.Field(i), key + ".", changed)
+		} else if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+			changed = append(changed, key)
+		}
+	}
+
+	return changed
+}
+
+//copies the fields that can't change without a restart from running into
+//reloaded, so the live config always describes what the server is doing.
+//returns the keys that were applied and the keys that need a restart
+func mergeReloadedConfig(running *Config, reloaded *Config) (applied []string, needs_restart []string) {
+	changed := diffConfigValues(reflect.ValueOf(*running), reflect.ValueOf(*reloaded), "", []string{})
+
+	for _, key := range changed {
+		top_level := strings.Split(key, ".")[0]
+
+		if reloadable_config_keys[top_level] {
+			applied = append(applied, key)
+		} else {
+			needs_restart = append(needs_restart, key)
+		}
+	}
+
+	running_value := reflect.ValueOf(running).Elem()
+	reloaded_value := reflect.ValueOf(reloaded).Elem()
+
+	for i := 0; i < running_value.NumField(); i++ {
+		json_name := strings.Split(running_value.Type().Field(i).Tag.Get("json"), ",")[0]
+
+		if !reloadable_config_keys[json_name] {
+			reloaded_value.Field(i).Set(running_value.Field(i))
+		}
+	}
+
+	return applied, needs_restart
+}
+
+//re-reads config the same way as startup. an invalid config is logged
+//and dropped, leaving the running config untouched
+func reloadConfig(flag_path string) error {
+	reloaded, err := initialiseConfig(flag_path)
+
+	if err != nil {
+		slog.Error("config reload rejected, keeping the running config", "err", err.Error())
+		return err
+	}
+
+	applied, needs_restart := mergeReloadedConfig(liveConfig(), reloaded)
+
+	for _, key := range needs_restart {
+		slog.Warn("config change ignored until restart", "key", key)
+	}
+
+	storeLiveConfig(reloaded)
+
+	slog.Info("config reloaded", "applied", applied)
+
+	return nil
+}
+
+//reloads on SIGHUP until ctx is cancelled
+func watchForConfigReload(ctx context.Context, jobs *sync.WaitGroup, flag_path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	jobs.Add(1)
+
+	go func() {
+		defer jobs.Done()
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				slog.Info("received SIGHUP, reloading config")
+				recordBackgroundJob("config_reload", reloadConfig(flag_path))
+			}
+		}
+	}()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, path string, json string) {
+	err := os.WriteFile(path, []byte(json), 0600)
+
+	if err != nil {
+		t.Fatalf("error writing config file for test: %s", err.Error())
+	}
+}
+
+func TestMergeReloadedConfig(t *testing.T) {
+	running := Config{ Host: "localhost", Port: 1800 }
+	running.Db.Host = "db-1"
+	running.Registration.Mode = REGISTRATION_OPEN
+
+	reloaded := running
+	reloaded.Port = 1900
+	reloaded.Db.Host = "db-2"
+	reloaded.Registration.Mode = REGISTRATION_CLOSED
+	reloaded.Session.Max_age = 60
+
+	applied, needs_restart := mergeReloadedConfig(&running, &reloaded)
+
+	if !slices.Equal(applied, []string{ "session.max_age", "registration.mode" }) {
+		t.Errorf("unexpected applied keys %v", applied)
+	}
+
+	if !slices.Equal(needs_restart, []string{ "port", "db.host" }) {
+		t.Errorf("unexpected restart keys %v", needs_restart)
+	}
+
+	if reloaded.Port != 1800 || reloaded.Db.Host != "db-1" {
+		t.Error("fields needing a restart should keep their running values")
+	}
+
+	if reloaded.Registration.Mode != REGISTRATION_CLOSED || reloaded.Session.Max_age != 60 {
+		t.Error("reloadable fields should take their new values")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	base := `"host": "localhost", "port": 1800, "db": { "host": "localhost", "port": 5432, "database_name": "goal", "username": "username", "password": "password" }`
+
+	writeTestConfig(t, path, `{ ` + base + ` }`)
+
+	conf, err := initialiseConfig(path)
+
+	if err != nil {
+		t.Fatalf("initial config failed: %s", err.Error())
+	}
+
+	storeLiveConfig(conf)
+	defer live_config.Store(nil)
+
+	writeTestConfig(t, path, `{ ` + base + `, "registration": { "mode": "closed" }, "session": { "max_age": 60 } }`)
+
+	err = reloadConfig(path)
+
+	if err != nil {
+		t.Fatalf("reload of valid config failed: %s", err.Error())
+	}
+
+	if liveConfig().Registration.Mode != REGISTRATION_CLOSED || sessionMaxAge() != 60 {
+		t.Error("reload did not apply registration and session changes")
+	}
+
+	writeTestConfig(t, path, `{ ` + strings.Replace(base, "1800", "1900", 1) + `, "registration": { "mode": "closed" } }`)
+
+	err = reloadConfig(path)
+
+	if err != nil {
+		t.Fatalf("reload of valid config failed: %s", err.Error())
+	}
+
+	if liveConfig().Port != 1800 {
+		t.Error("port changed without a restart")
+	}
+
+	writeTestConfig(t, path, `{ ` + base + `, "registration": { "mode": "sometimes" } }`)
+
+	err = reloadConfig(path)
+
+	if err == nil {
+		t.Error("reload of invalid config should fail")
+	}
+
+	if liveConfig().Registration.Mode != REGISTRATION_CLOSED {
+		t.Error("rejected reload changed the running config")
+	}
+}
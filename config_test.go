@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -70,7 +72,7 @@ func TestValidateIncompleteConfig(t *testing.T) {
 
 	defer file.Close()
 
-	_, err = file.Write([]byte("{\"db\": { \"host\": null }}"))
+	_, err = file.Write([]byte("{\"db\": {}}"))
 
 	if err != nil {
 		t.Error("error writing to config file for test")
@@ -125,3 +127,276 @@ func TestValidateCompleteConfig(t *testing.T) {
 	}
 }
 
+//passwords and paths from config.json reach the connection string intact
+func TestParseConfigDbTrickyCharacters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	json_str := `{
+		"host": "localhost",
+		"port": 1800,
+		"db": {
+			"host": "localhost",
+			"port": 5432,
+			"database_name": "goal db",
+			"username": "goal",
+			"password": "p a\\ss'w\"ord=",
+			"sslmode": "verify-full",
+			"ssl_root_cert": "/etc/goal/it's/root.crt",
+			"application_name": "goal"
+		}
+	}`
+
+	if err := os.WriteFile(path, []byte(json_str), 0600); err != nil {
+		t.Fatalf("error writing config file: %s", err.Error())
+	}
+
+	conf, err := parseConfig(path)
+
+	if err != nil {
+		t.Fatalf("parseConfig should not fail: %s", err.Error())
+	}
+
+	if err = validateConfig(conf); err != nil {
+		t.Fatalf("validateConfig should not fail: %s", err.Error())
+	}
+
+	if conf.Db.Password != `p a\ss'w"ord=` {
+		t.Errorf("password not parsed as written. got: %s", conf.Db.Password)
+	}
+
+	dsn, err := buildPostgresDSN(&conf.Db)
+	expected := `host='localhost' port='5432' dbname='goal db' user='goal' password='p a\\ss\'w"ord=' ` +
+		`sslmode='verify-full' sslrootcert='/etc/goal/it\'s/root.crt' application_name='goal'`
+
+	if err != nil || dsn != expected {
+		t.Errorf("expected %s\ngot: %s %v", expected, dsn, err)
+	}
+}
+
+func mapLookup(env map[string]string) envLookup {
+	return func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+}
+
+func TestResolveConfigPath(t *testing.T) {
+	tests := []struct {
+		name string
+		flag_path string
+		env map[string]string
+		expected_path string
+		expected_explicit bool
+	}{
+		{ "default", "", map[string]string{}, DEFAULT_CONFIG_PATH, false },
+		{ "env", "", map[string]string{ CONFIG_PATH_ENV: "/etc/goal/env.json" }, "/etc/goal/env.json", true },
+		{ "flag", "/etc/goal/flag.json", map[string]string{}, "/etc/goal/flag.json", true },
+		{
+			"flag beats env",
+			"/etc/goal/flag.json",
+			map[string]string{ CONFIG_PATH_ENV: "/etc/goal/env.json" },
+			"/etc/goal/flag.json",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		path, explicit := resolveConfigPath(test.flag_path, mapLookup(test.env))
+
+		if path != test.expected_path || explicit != test.expected_explicit {
+			t.Errorf("%s: expected %s %t. got: %s %t", test.name, test.expected_path, test.expected_explicit, path, explicit)
+		}
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	secret_dir := t.TempDir()
+	password_file := secret_dir + "/db_password"
+	os.WriteFile(password_file, []byte("file password\n"), 0600)
+
+	tests := []struct {
+		name string
+		env map[string]string
+		check func(conf *Config) bool
+	}{
+		{
+			"no env keeps file values",
+			map[string]string{},
+			func(conf *Config) bool { return conf.Host == "filehost" && conf.Db.Password == "filepassword" },
+		},
+		{
+			"top level string and number",
+			map[string]string{ "GOALTRACKER_HOST": "envhost", "GOALTRACKER_PORT": "8080" },
+			func(conf *Config) bool { return conf.Host == "envhost" && conf.Port == 8080 },
+		},
+		{
+			"nested field",
+			map[string]string{ "GOALTRACKER_DB_PASSWORD": "envpassword" },
+			func(conf *Config) bool { return conf.Db.Password == "envpassword" && conf.Db.Host == "filehost" },
+		},
+		{
+			"file variant trims trailing newline",
+			map[string]string{ "GOALTRACKER_DB_PASSWORD_FILE": password_file },
+			func(conf *Config) bool { return conf.Db.Password == "file password" },
+		},
+		{
+			"db dsn and connect timeout",
+			map[string]string{ "GOALTRACKER_DB_DSN": "postgres://goal:p%27ss@db/goal", "GOALTRACKER_DB_CONNECT_TIMEOUT": "5" },
+			func(conf *Config) bool { return conf.Db.Dsn == "postgres://goal:p%27ss@db/goal" && conf.Db.Connect_timeout == 5 },
+		},
+		{
+			"field named like a file variant",
+			map[string]string{ "GOALTRACKER_ARGON2_PEPPER_FILE": "/run/secrets/pepper" },
+			func(conf *Config) bool { return conf.Argon2.Pepper_file == "/run/secrets/pepper" },
+		},
+		{
+			"bool",
+			map[string]string{ "GOALTRACKER_SESSION_INSECURE_COOKIES": "true" },
+			func(conf *Config) bool { return conf.Session.Insecure_cookies },
+		},
+		{
+			"comma separated list",
+			map[string]string{ "GOALTRACKER_REGISTRATION_ALLOWED_DOMAINS": "example.com, example.org," },
+			func(conf *Config) bool {
+				domains := conf.Registration.Allowed_domains
+				return len(domains) == 2 && domains[0] == "example.com" && domains[1] == "example.org"
+			},
+		},
+		{
+			"json list of objects",
+			map[string]string{ "GOALTRACKER_OIDC_PROVIDERS": `[{"name": "corp", "issuer": "https://idp.example.com"}]` },
+			func(conf *Config) bool {
+				return len(conf.Oidc_providers) == 1 && conf.Oidc_providers[0].Issuer == "https://idp.example.com"
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var conf Config
+		conf.Host = "filehost"
+		conf.Db.Host = "filehost"
+		conf.Db.Password = "filepassword"
+
+		err := applyEnvOverrides(&conf, mapLookup(test.env))
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+		} else if !test.check(&conf) {
+			t.Errorf("%s: config was not as expected. got: %+v", test.name, conf)
+		}
+	}
+}
+
+func TestApplyEnvOverridesErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env map[string]string
+	}{
+		{ "port out of range", map[string]string{ "GOALTRACKER_PORT": "70000" } },
+		{ "port not a number", map[string]string{ "GOALTRACKER_DB_PORT": "postgres" } },
+		{ "bad bool", map[string]string{ "GOALTRACKER_SESSION_INSECURE_COOKIES": "sometimes" } },
+		{ "bad json", map[string]string{ "GOALTRACKER_OIDC_PROVIDERS": "corp" } },
+		{ "missing file", map[string]string{ "GOALTRACKER_DB_PASSWORD_FILE": "/nonexistent/password" } },
+		{
+			"value and file both set",
+			map[string]string{
+				"GOALTRACKER_DB_PASSWORD": "password",
+				"GOALTRACKER_DB_PASSWORD_FILE": "/run/secrets/password",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var conf Config
+		err := applyEnvOverrides(&conf, mapLookup(test.env))
+
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func TestEmbeddedSchemaParses(t *testing.T) {
+	_, err := parseSchema(config_schema_json)
+
+	if err != nil {
+		t.Errorf("embedded config schema should parse: %s", err.Error())
+	}
+}
+
+func TestValidateConfigJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		violations []string
+	}{
+		{ "empty object", `{}`, []string{} },
+		{ "null host", `{"db": {"host": null}}`, []string{ "/db/host: expected string but got null" } },
+		{ "port as string", `{"port": "1800"}`, []string{ "/port: expected integer but got string" } },
+		{ "unknown top level key", `{"hots": "localhost"}`, []string{ "/hots: unknown property" } },
+		{ "unknown nested key", `{"db": {"pasword": "secret"}}`, []string{ "/db/pasword: unknown property" } },
+		{ "bad registration mode", `{"registration": {"mode": "sometimes"}}`, []string{ "/registration/mode: must be one of" } },
+		{
+			"provider missing required keys",
+			`{"oidc_providers": [{"name": "corp"}]}`,
+			[]string{ `/oidc_providers/0: missing required property "issuer"` },
+		},
+		{
+			"every violation reported",
+			`{"port": "1800", "db": {"port": true}, "extra": 1}`,
+			[]string{
+				"/db/port: expected integer but got boolean",
+				"/extra: unknown property",
+				"/port: expected integer but got string",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		err := validateConfigJSON([]byte(test.json))
+
+		if len(test.violations) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.name, err.Error())
+			}
+
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+			continue
+		}
+
+		for _, violation := range test.violations {
+			if !strings.Contains(err.Error(), violation) {
+				t.Errorf("%s: expected %q in error, got %q", test.name, violation, err.Error())
+			}
+		}
+	}
+}
+
+func TestValidateConfigReportsAllMissing(t *testing.T) {
+	conf := Config{
+		Host: "localhost",
+		Oidc_providers: []OidcProviderConfig{
+			{ Name: "corp", Issuer: "https://id.example.com", Client_id: "goal", Redirect_url: "https://goal.example.com/cb" },
+			{ Name: "corp" },
+		},
+	}
+
+	err := validateConfig(&conf)
+
+	if err == nil {
+		t.Fatal("validate config should fail with missing properties")
+	}
+
+	for _, pointer := range []string{ "/port", "/db/host", "/db/password", "/oidc_providers/1/issuer", "/oidc_providers/1/name: duplicate" } {
+		if !strings.Contains(err.Error(), pointer) {
+			t.Errorf("expected %q in error, got %q", pointer, err.Error())
+		}
+	}
+
+	if strings.Contains(err.Error(), "\n/host:") {
+		t.Errorf("host is set and should not be reported, got %q", err.Error())
+	}
+}
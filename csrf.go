@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+)
+
+const CSRF_TOKEN_LEN_BYTE = 32
+const CSRF_COOKIE_NAME = "csrf_token"
+const CSRF_FORM_FIELD = "csrf_token"
+const CSRF_HEADER = "X-CSRF-Token"
+
+func newCsrfCookie(token string) *http.Cookie {
+	return &http.Cookie{
+		Name: CSRF_COOKIE_NAME,
+		Value: token,
+		Path: "/",
+		HttpOnly: true,
+		Secure: secureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+func isStateChangingMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	default:
+		return true
+	}
+}
+
+//returns the token sent with the request in either the header
+//or the form body. header takes priority so that fetch calls
+//without a form body can still pass the check
+func requestCsrfToken(r *http.Request) string {
+	token := r.Header.Get(CSRF_HEADER)
+
+	if token != "" {
+		return token
+	}
+
+	return r.PostFormValue(CSRF_FORM_FIELD)
+}
+
+//double submit cookie protection. every response gets a csrf_token cookie
+//if it doesn't already have one, and the token is put in the request context
+//for templates to embed. state changing requests must echo the cookie value
+//back in either the X-CSRF-Token header or the csrf_token form field
+func csrfMiddleware(next http.Handler) http.Handler {
+	handler_func := func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		cookie, err := r.Cookie(CSRF_COOKIE_NAME)
+
+		if err == nil && len(cookie.Value) == CSRF_TOKEN_LEN_BYTE * 2 {
+			token = cookie.Value
+		}
+
+		if isStateChangingMethod(r.Method) {
+			sent_token := requestCsrfToken(r)
+
+			if token == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(sent_token)) != 1 {
+				requestLogger(r).Info(
+					"csrf token missing or mismatched",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"response_code", http.StatusForbidden,
+				)
+
+				http.Error(w, "invalid csrf token", http.StatusForbidden)
+				return
+			}
+		}
+
+		if token == "" {
+			token, err = generateSessionId(CSRF_TOKEN_LEN_BYTE)
+
+			if err != nil {
+				requestLogger(r).Error(
+					"error generating csrf token",
+					"err", err.Error(),
+					"response_code", http.StatusInternalServerError,
+				)
+
+				http.Error(w, "unknown error", http.StatusInternalServerError)
+				return
+			}
+
+			http.SetCookie(w, newCsrfCookie(token))
+		}
+
+		ctx := context.WithValue(r.Context(), "csrf_token", token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(handler_func)
+}
+
+//returns empty string when the request didn't pass through csrfMiddleware
+func csrfTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value("csrf_token").(string)
+	return token
+}
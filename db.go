@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const DEFAULT_DB_MAX_OPEN_CONNS = 20
+const DEFAULT_DB_MAX_IDLE_CONNS = 10
+const DEFAULT_DB_CONN_MAX_LIFETIME = 30 * 60
+const DEFAULT_DB_CONN_MAX_IDLE_TIME = 5 * 60
+const DEFAULT_DB_QUERY_TIMEOUT = 10
+const DB_PING_TIMEOUT = 5 * time.Second
+const DEFAULT_DB_STARTUP_ATTEMPTS = 10
+const DB_STARTUP_BACKOFF_BASE = 500 * time.Millisecond
+const DB_STARTUP_BACKOFF_MAX = 15 * time.Second
+
+const DB_DRIVER_POSTGRES = "postgres"
+const DB_DRIVER_SQLITE = "sqlite"
+
+//used when neither sslmode nor dsn is set, as it always was before
+//sslmode was configurable
+const DEFAULT_DB_SSLMODE = "disable"
+
+var db_sslmodes = []string{ "disable", "allow", "prefer", "require", "verify-ca", "verify-full" }
+
+type DbConfig struct {
+	//postgres or sqlite. defaults to postgres
+	Driver        string `json:"driver"`
+	//sqlite database file, created if it doesn't exist. the other
+	//connection settings only apply to postgres
+	Path          string `json:"path"`
+	//a key=value connection string or postgres:// url. any of the fields
+	//below that are set take precedence over the same setting in it
+	Dsn           string `json:"dsn"`
+	Host          string `json:"host"`
+	Port          uint16 `json:"port"`
+	Database_name string `json:"database_name"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Sslmode          string `json:"sslmode"`
+	//paths to pem files
+	Ssl_root_cert    string `json:"ssl_root_cert"`
+	Ssl_cert         string `json:"ssl_cert"`
+	Ssl_key          string `json:"ssl_key"`
+	Application_name string `json:"application_name"`
+	Search_path      string `json:"search_path"`
+	//seconds, zero waits indefinitely
+	Connect_timeout  uint32 `json:"connect_timeout"`
+	//pool settings, zero values fall back to the defaults above.
+	//lifetimes and timeouts are in seconds
+	Max_open_conns     uint32 `json:"max_open_conns"`
+	Max_idle_conns     uint32 `json:"max_idle_conns"`
+	Conn_max_lifetime  uint32 `json:"conn_max_lifetime"`
+	Conn_max_idle_time uint32 `json:"conn_max_idle_time"`
+	//upper bound on each sql.go call, on top of the request being cancelled
+	Query_timeout      uint32 `json:"query_timeout"`
+	//pings made at startup before giving up on the db, so the app can
+	//start before postgres is accepting connections
+	Startup_attempts   uint32 `json:"startup_attempts"`
+}
+
+func validateDbConfig(conf *DbConfig) []error {
+	violations := []error{}
+
+	addMissing := func(pointer string) {
+		violations = append(violations, schemaViolation{ pointer: pointer, message: "missing required value" })
+	}
+
+	switch conf.Driver {
+	case DB_DRIVER_SQLITE:
+		if conf.Path == "" {
+			addMissing("/db/path")
+		}
+	case "", DB_DRIVER_POSTGRES:
+		violations = append(violations, validatePostgresConfig(conf)...)
+	default:
+		violations = append(violations, schemaViolation{
+			pointer: "/db/driver",
+			message: conf.Driver + " is not one of postgres, sqlite",
+		})
+	}
+
+	return violations
+}
+
+func validatePostgresConfig(conf *DbConfig) []error {
+	violations := []error{}
+
+	addMissing := func(pointer string) {
+		violations = append(violations, schemaViolation{ pointer: pointer, message: "missing required value" })
+	}
+
+	//a dsn can carry any of the connection settings, so none are required
+	//alongside it
+	if conf.Dsn == "" {
+		if conf.Host == "" {
+			addMissing("/db/host")
+		}
+		if conf.Port == 0 {
+			addMissing("/db/port")
+		}
+		if conf.Database_name == "" {
+			addMissing("/db/database_name")
+		}
+		if conf.Username == "" {
+			addMissing("/db/username")
+		}
+		if conf.Password == "" {
+			addMissing("/db/password")
+		}
+	} else if _, err := buildPostgresDSN(conf); err != nil {
+		//the error is left out as it may quote part of the password
+		violations = append(violations, schemaViolation{
+			pointer: "/db/dsn",
+			message: "is not a valid connection string or url",
+		})
+	}
+
+	if conf.Sslmode != "" && !slices.Contains(db_sslmodes, conf.Sslmode) {
+		violations = append(violations, schemaViolation{
+			pointer: "/db/sslmode",
+			message: conf.Sslmode + " is not one of " + strings.Join(db_sslmodes, ", "),
+		})
+	}
+
+	if (conf.Ssl_cert == "") != (conf.Ssl_key == "") {
+		violations = append(violations, schemaViolation{
+			pointer: "/db/ssl_key",
+			message: "ssl_cert and ssl_key must be set together",
+		})
+	}
+
+	return violations
+}
+
+//single quotes every value so spaces, quotes and backslashes survive,
+//escaped the same way as pq.ParseURL
+var db_dsn_escaper = strings.NewReplacer(`'`, `\'`, `\`, `\\`)
+
+func quoteDSNValue(value string) string {
+	return "'" + db_dsn_escaper.Replace(value) + "'"
+}
+
+//the lib/pq connection string for conf. the result contains the password
+//so must never be logged
+func buildPostgresDSN(conf *DbConfig) (string, error) {
+	dsn := strings.TrimSpace(conf.Dsn)
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		parsed, err := pq.ParseURL(dsn)
+
+		if err != nil {
+			return "", err
+		}
+
+		dsn = parsed
+	}
+
+	params := []string{}
+
+	if dsn != "" {
+		params = append(params, dsn)
+	}
+
+	//lib/pq keeps the last value given for a key, so these override the dsn
+	add := func(key string, value string) {
+		if value != "" {
+			params = append(params, key + "=" + quoteDSNValue(value))
+		}
+	}
+
+	add("host", conf.Host)
+
+	if conf.Port != 0 {
+		add("port", strconv.Itoa(int(conf.Port)))
+	}
+
+	add("dbname", conf.Database_name)
+	add("user", conf.Username)
+	add("password", conf.Password)
+
+	sslmode := conf.Sslmode
+
+	if sslmode == "" && conf.Dsn == "" {
+		sslmode = DEFAULT_DB_SSLMODE
+	}
+
+	add("sslmode", sslmode)
+	add("sslrootcert", conf.Ssl_root_cert)
+	add("sslcert", conf.Ssl_cert)
+	add("sslkey", conf.Ssl_key)
+	add("application_name", conf.Application_name)
+	add("search_path", conf.Search_path)
+
+	if conf.Connect_timeout != 0 {
+		add("connect_timeout", strconv.Itoa(int(conf.Connect_timeout)))
+	}
+
+	dsn = strings.Join(params, " ")
+
+	//parses without connecting, catching unterminated quotes and the like
+	if _, err := pq.NewConnector(dsn); err != nil {
+		return "", err
+	}
+
+	return dsn, nil
+}
+
+func applyDBPoolConfig(db *sql.DB, conf *DbConfig) {
+	max_open_conns := DEFAULT_DB_MAX_OPEN_CONNS
+	max_idle_conns := DEFAULT_DB_MAX_IDLE_CONNS
+
+	if conf.Max_open_conns != 0 {
+		max_open_conns = int(conf.Max_open_conns)
+	}
+
+	if conf.Max_idle_conns != 0 {
+		max_idle_conns = int(conf.Max_idle_conns)
+	}
+
+	db.SetMaxOpenConns(max_open_conns)
+	//SetMaxIdleConns caps itself at max open conns
+	db.SetMaxIdleConns(max_idle_conns)
+	db.SetConnMaxLifetime(secondsOrDefault(conf.Conn_max_lifetime, DEFAULT_DB_CONN_MAX_LIFETIME))
+	db.SetConnMaxIdleTime(secondsOrDefault(conf.Conn_max_idle_time, DEFAULT_DB_CONN_MAX_IDLE_TIME))
+}
+
+//the wait before retrying after the given failed attempt, counting from 1.
+//doubles from base up to max, then jitter picks anywhere from half to the
+//full delay so instances started together don't retry in lockstep
+func backoffDelay(attempt int, base time.Duration, max time.Duration) time.Duration {
+	delay := max
+
+	//stops the shift overflowing on a long run of attempts
+	if attempt < 32 {
+		if doubled := base << (attempt - 1); doubled > 0 && doubled < max {
+			delay = doubled
+		}
+	}
+
+	half := delay / 2
+
+	if delay - half <= 0 {
+		return delay
+	}
+
+	return half + rand.N(delay - half)
+}
+
+//calls try until it succeeds, it has been called attempts times or ctx is
+//cancelled, waiting backoffDelay between calls. every failure is logged
+func retryWithBackoff(
+	ctx context.Context,
+	operation string,
+	attempts int,
+	base time.Duration,
+	max time.Duration,
+	try func(ctx context.Context) error,
+) error {
+	for attempt := 1; ; attempt++ {
+		err := try(ctx)
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= attempts {
+			slog.Error(operation + " failed, giving up", "attempt", attempt, "max_attempts", attempts, "err", err.Error())
+			return err
+		}
+
+		delay := backoffDelay(attempt, base, max)
+
+		slog.Warn(
+			operation + " failed, retrying",
+			"attempt", attempt,
+			"max_attempts", attempts,
+			"retry_in", delay.String(),
+			"err", err.Error(),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+//starts the span for a sql.go or sqlite.go call and bounds it by the query timeout.
+//the returned func must be called once the call, including reading its
+//rows, is finished
+func startDBCall(ctx context.Context, operation string) (context.Context, func()) {
+	conf := liveConfig().Db
+	ctx, cancel := context.WithTimeout(ctx, secondsOrDefault(conf.Query_timeout, DEFAULT_DB_QUERY_TIMEOUT))
+
+	db_system := semconv.DBSystemPostgreSQL
+
+	if conf.Driver == DB_DRIVER_SQLITE {
+		db_system = semconv.DBSystemSqlite
+	}
+
+	ctx, span := tracer.Start(
+		ctx,
+		"db." + operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			db_system,
+			attribute.String("db.operation.name", operation),
+		),
+	)
+
+	return ctx, func() {
+		span.End()
+		cancel()
+	}
+}
@@ -0,0 +1,3 @@
+-- password_params grows with argon2 key/salt length and the pepper flag,
+-- so the original VARCHAR(80) is too narrow once params are configurable
+ALTER TABLE User_ ALTER COLUMN password_params TYPE VARCHAR(255);
@@ -0,0 +1,11 @@
+-- links an external OpenID Connect identity to a local user.
+-- users provisioned through OIDC have password_params set to '!'
+CREATE TABLE UserIdentity (
+  issuer VARCHAR(255) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  username VARCHAR(100) NOT NULL REFERENCES User_(username),
+  created_datetime TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX idx_user_identity_username ON UserIdentity (username);
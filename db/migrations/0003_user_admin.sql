@@ -0,0 +1,6 @@
+-- admin role, account disabling and last login shown in the admin console.
+-- the first admin can be set with admin_usernames in config.json
+ALTER TABLE User_
+  ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN last_login_datetime TIMESTAMPTZ;
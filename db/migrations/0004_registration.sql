@@ -0,0 +1,18 @@
+-- invite codes for invite-only registration and the email used for
+-- domain allowlisted registration. codes are stored hashed, the plain
+-- code is only shown to the admin when it is generated
+CREATE TABLE InviteCode (
+  id SERIAL PRIMARY KEY,
+  code_sha256 BYTEA NOT NULL UNIQUE,
+  created_by VARCHAR(100) NOT NULL,
+  created_datetime TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_datetime TIMESTAMPTZ,
+  max_uses INTEGER NOT NULL DEFAULT 1 CHECK (max_uses > 0),
+  use_count INTEGER NOT NULL DEFAULT 0,
+  revoked BOOLEAN NOT NULL DEFAULT FALSE,
+  note VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+ALTER TABLE User_
+  ADD COLUMN email VARCHAR(254),
+  ADD COLUMN invite_code_id INTEGER REFERENCES InviteCode(id) ON DELETE SET NULL;
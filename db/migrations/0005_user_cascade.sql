@@ -0,0 +1,15 @@
+-- deleting a user removes everything they own
+ALTER TABLE Goal
+  DROP CONSTRAINT goal_username_fkey,
+  ADD CONSTRAINT goal_username_fkey
+    FOREIGN KEY (username) REFERENCES User_(username) ON DELETE CASCADE;
+
+ALTER TABLE SessionId
+  DROP CONSTRAINT sessionid_username_fkey,
+  ADD CONSTRAINT sessionid_username_fkey
+    FOREIGN KEY (username) REFERENCES User_(username) ON DELETE CASCADE;
+
+ALTER TABLE UserIdentity
+  DROP CONSTRAINT useridentity_username_fkey,
+  ADD CONSTRAINT useridentity_username_fkey
+    FOREIGN KEY (username) REFERENCES User_(username) ON DELETE CASCADE;
@@ -0,0 +1,15 @@
+-- security audit log. username is not a foreign key so events outlive
+-- the user, deleting an account anonymises its events instead
+CREATE TABLE AuditEvent (
+  id BIGSERIAL PRIMARY KEY,
+  created_datetime TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  event_type VARCHAR(50) NOT NULL,
+  username VARCHAR(100) NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  user_agent VARCHAR(512) NOT NULL,
+  outcome VARCHAR(10) NOT NULL,
+  detail VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_audit_event_created ON AuditEvent (created_datetime);
+CREATE INDEX idx_audit_event_username_created ON AuditEvent (username, created_datetime);
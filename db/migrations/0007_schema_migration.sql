@@ -0,0 +1,9 @@
+-- records which migrations have been applied so /readyz can tell when the
+-- schema is behind the code. every later migration ends by inserting its
+-- own version
+CREATE TABLE SchemaMigration (
+  version INT PRIMARY KEY,
+  applied_datetime TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO SchemaMigration (version) VALUES (1), (2), (3), (4), (5), (6), (7);
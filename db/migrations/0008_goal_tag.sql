@@ -0,0 +1,11 @@
+-- free-form labels on goals. a goal can have several tags, which are
+-- deleted along with it
+CREATE TABLE GoalTag (
+  goal_id INT NOT NULL REFERENCES Goal(id) ON DELETE CASCADE,
+  tag VARCHAR(50) NOT NULL,
+  PRIMARY KEY (goal_id, tag)
+);
+
+CREATE INDEX idx_goal_tag_tag ON GoalTag (tag);
+
+INSERT INTO SchemaMigration (version) VALUES (8);
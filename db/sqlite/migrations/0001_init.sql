@@ -0,0 +1,72 @@
+-- the sqlite schema, equivalent to postgres with db/create.sql and
+-- db/migrations 0001 to 0007 applied. sqlite migrations are applied on
+-- startup by applySqliteMigrations, which also records their versions.
+--
+-- dates are stored as YYYY-MM-DD text and timestamps as UTC text in the
+-- format strftime('%Y-%m-%d %H:%M:%f') writes, so both sort and compare
+-- correctly as strings. the declared DATE and TIMESTAMP types make the
+-- driver scan them back into time.Time
+CREATE TABLE User_ (
+  username VARCHAR(100) PRIMARY KEY,
+  password_params VARCHAR(255) NOT NULL,
+  is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+  disabled BOOLEAN NOT NULL DEFAULT FALSE,
+  last_login_datetime TIMESTAMP,
+  email VARCHAR(254),
+  invite_code_id INTEGER REFERENCES InviteCode(id) ON DELETE SET NULL
+);
+
+CREATE TABLE Goal (
+  id INTEGER PRIMARY KEY,
+  title VARCHAR(255) NOT NULL,
+  start_date DATE NOT NULL,
+  end_date DATE NOT NULL,
+  completed_datetime TIMESTAMP,
+  notes VARCHAR(1000),
+  username VARCHAR(100) NOT NULL REFERENCES User_(username) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_goal_username_end_date ON Goal (username, end_date);
+
+CREATE TABLE SessionId (
+  username VARCHAR(100) NOT NULL PRIMARY KEY REFERENCES User_(username) ON DELETE CASCADE,
+  session_id_sha256 BLOB NOT NULL
+);
+
+CREATE INDEX idx_session_id_sha256 ON SessionId (session_id_sha256);
+
+CREATE TABLE UserIdentity (
+  issuer VARCHAR(255) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  username VARCHAR(100) NOT NULL REFERENCES User_(username) ON DELETE CASCADE,
+  created_datetime TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  PRIMARY KEY (issuer, subject)
+);
+
+CREATE INDEX idx_user_identity_username ON UserIdentity (username);
+
+CREATE TABLE InviteCode (
+  id INTEGER PRIMARY KEY,
+  code_sha256 BLOB NOT NULL UNIQUE,
+  created_by VARCHAR(100) NOT NULL,
+  created_datetime TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  expires_datetime TIMESTAMP,
+  max_uses INTEGER NOT NULL DEFAULT 1 CHECK (max_uses > 0),
+  use_count INTEGER NOT NULL DEFAULT 0,
+  revoked BOOLEAN NOT NULL DEFAULT FALSE,
+  note VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE AuditEvent (
+  id INTEGER PRIMARY KEY,
+  created_datetime TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+  event_type VARCHAR(50) NOT NULL,
+  username VARCHAR(100) NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  user_agent VARCHAR(512) NOT NULL,
+  outcome VARCHAR(10) NOT NULL,
+  detail VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX idx_audit_event_created ON AuditEvent (created_datetime);
+CREATE INDEX idx_audit_event_username_created ON AuditEvent (username, created_datetime);
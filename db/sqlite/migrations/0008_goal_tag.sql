@@ -0,0 +1,9 @@
+-- equivalent to db/migrations/0008_goal_tag.sql. the version is recorded
+-- by applySqliteMigrations
+CREATE TABLE GoalTag (
+  goal_id INTEGER NOT NULL REFERENCES Goal(id) ON DELETE CASCADE,
+  tag VARCHAR(50) NOT NULL,
+  PRIMARY KEY (goal_id, tag)
+);
+
+CREATE INDEX idx_goal_tag_tag ON GoalTag (tag);
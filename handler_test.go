@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+//a browser-like client against the full handler stack. the flows run
+//against every Store, postgres only when GOAL_TEST_DB_DSN is set
+type testClient struct {
+	t *testing.T
+	server *httptest.Server
+	client *http.Client
+}
+
+func newTestServer(t *testing.T, store Store, conf Config) *httptest.Server {
+	//plain http, so the cookie jar needs them without the secure flag
+	conf.Session.Insecure_cookies = true
+	live_config.Store(&conf)
+	t.Cleanup(func() { live_config.Store(nil) })
+
+	handler := initialiseHTTPServer(store, &conf)
+
+	if handler == nil {
+		t.Fatal("error initialising http server")
+	}
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestClient(t *testing.T, server *httptest.Server) *testClient {
+	jar, _ := cookiejar.New(nil)
+
+	client := &http.Client{
+		Jar: jar,
+		//redirects are asserted on rather than followed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	c := &testClient{ t: t, server: server, client: client }
+
+	//picks up the csrf cookie
+	c.get("/login")
+
+	return c
+}
+
+func (c *testClient) cookie(name string) string {
+	server_url, _ := url.Parse(c.server.URL)
+
+	for _, cookie := range c.client.Jar.Cookies(server_url) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+
+	return ""
+}
+
+func (c *testClient) do(req *http.Request) (int, string, http.Header) {
+	res, err := c.client.Do(req)
+
+	if err != nil {
+		c.t.Fatalf("error sending %s %s: %s", req.Method, req.URL.Path, err.Error())
+	}
+
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+
+	return res.StatusCode, string(body), res.Header
+}
+
+func (c *testClient) get(path string) (int, string, http.Header) {
+	req, _ := http.NewRequest(http.MethodGet, c.server.URL + path, nil)
+	return c.do(req)
+}
+
+func (c *testClient) post(path string, form url.Values) (int, string, http.Header) {
+	req, _ := http.NewRequest(http.MethodPost, c.server.URL + path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(CSRF_HEADER, c.cookie(CSRF_COOKIE_NAME))
+
+	return c.do(req)
+}
+
+func (c *testClient) register(username string, password string) int {
+	code, _, _ := c.post("/register", url.Values{ "username": { username }, "password": { password } })
+	return code
+}
+
+func (c *testClient) login(username string, password string) int {
+	code, _, _ := c.post("/login", url.Values{ "username": { username }, "password": { password } })
+	return code
+}
+
+var handler_tests = []struct{
+	name string
+	run func(t *testing.T, store Store)
+}{
+	{ name: "register login logout", run: testHandlerRegisterLoginLogout },
+	{ name: "goals", run: testHandlerGoals },
+	{ name: "stats", run: testHandlerStats },
+	{ name: "invite registration", run: testHandlerInviteRegistration },
+	{ name: "admin disable user", run: testHandlerAdminDisableUser },
+	{ name: "account export and delete", run: testHandlerAccountExportAndDelete },
+}
+
+func runHandlerTests(t *testing.T, newStore func(t *testing.T) Store) {
+	for _, test := range handler_tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newStore(t))
+		})
+	}
+}
+
+func TestHandlersMemoryStore(t *testing.T) {
+	runHandlerTests(t, func(t *testing.T) Store {
+		return newMemoryStore()
+	})
+}
+
+func TestHandlersSqliteStore(t *testing.T) {
+	runHandlerTests(t, func(t *testing.T) Store {
+		return openTestSqliteStore(t)
+	})
+}
+
+func TestHandlersPostgresStore(t *testing.T) {
+	runHandlerTests(t, func(t *testing.T) Store {
+		return newPostgresStore(openTestDB(t))
+	})
+}
+
+func testHandlerRegisterLoginLogout(t *testing.T, store Store) {
+	server := newTestServer(t, store, Config{})
+	c := newTestClient(t, server)
+
+	if code := c.register("alice", "password1"); code != http.StatusCreated {
+		t.Fatalf("expected register to return %d. got: %d", http.StatusCreated, code)
+	}
+
+	if code := c.register("alice", "password2"); code != http.StatusConflict {
+		t.Errorf("expected duplicate register to return %d. got: %d", http.StatusConflict, code)
+	}
+
+	if code := c.register("bob", "short"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected short password to return %d. got: %d", http.StatusUnprocessableEntity, code)
+	}
+
+	if code := c.register(strings.Repeat("b", USERNAME_MAX_LEN + 1), "password1"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected overlong username to return %d. got: %d", http.StatusUnprocessableEntity, code)
+	}
+
+	//deleted accounts' audit events are reassigned to it
+	if code := c.register(DELETED_USERNAME, "password1"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected %s to be reserved. got: %d", DELETED_USERNAME, code)
+	}
+
+	if code, _, header := c.get("/"); code != http.StatusSeeOther || header.Get("Location") != "/login" {
+		t.Errorf("expected redirect to /login before logging in. got: %d %s", code, header.Get("Location"))
+	}
+
+	if code := c.login("alice", "wrong password"); code != http.StatusUnauthorized {
+		t.Errorf("expected wrong password to return %d. got: %d", http.StatusUnauthorized, code)
+	}
+
+	if code := c.login("alice", "password1"); code != http.StatusOK {
+		t.Fatalf("expected login to return %d. got: %d", http.StatusOK, code)
+	}
+
+	if code, _, _ := c.get("/"); code != http.StatusOK {
+		t.Errorf("expected home page once logged in. got: %d", code)
+	}
+
+	if code, _, header := c.post("/logout", url.Values{}); code != http.StatusSeeOther || header.Get("Location") != "/login" {
+		t.Errorf("expected logout to redirect to /login. got: %d %s", code, header.Get("Location"))
+	}
+
+	if code, _, _ := c.get("/"); code != http.StatusSeeOther {
+		t.Errorf("expected session to be gone after logout. got: %d", code)
+	}
+}
+
+func testHandlerGoals(t *testing.T, store Store) {
+	server := newTestServer(t, store, Config{})
+	c := newTestClient(t, server)
+
+	c.register("alice", "password1")
+	c.login("alice", "password1")
+
+	form := url.Values{
+		"title": { "past", "future" },
+		"start": { "2024-01-01", "2024-01-01" },
+		"due": { "2024-01-10", "2024-03-01" },
+		"notes": { "", "notes" },
+	}
+
+	if code, _, _ := c.post("/goals", form); code != http.StatusOK {
+		t.Fatalf("expected goals post to return %d. got: %d", http.StatusOK, code)
+	}
+
+	if code, _, _ := c.post("/goals", url.Values{ "title": { "no start" } }); code != http.StatusBadRequest {
+		t.Errorf("expected goal without a start to return %d. got: %d", http.StatusBadRequest, code)
+	}
+
+	query := func(status string) string {
+		params := url.Values{
+			"start": { "2024-01-01" },
+			"end": { "2024-12-31" },
+			"now": { "2024-02-01" },
+			"status": { status },
+		}
+
+		return "/goals?" + params.Encode()
+	}
+
+	code, body, _ := c.get(query("Failed"))
+
+	if code != http.StatusOK || !strings.Contains(body, "past") || strings.Contains(body, "future") {
+		t.Errorf("expected only the past goal as failed. got: %d %s", code, body)
+	}
+
+	code, body, _ = c.get(query("In progress"))
+
+	if code != http.StatusOK || !strings.Contains(body, "future") || strings.Contains(body, "past") {
+		t.Errorf("expected only the future goal in progress. got: %d %s", code, body)
+	}
+
+	if code, _, _ := c.get(query("Complete")); code != http.StatusNoContent {
+		t.Errorf("expected no complete goals. got: %d", code)
+	}
+
+	//goals aren't visible to other users
+	other := newTestClient(t, server)
+	other.register("bob", "password1")
+	other.login("bob", "password1")
+
+	if code, _, _ := other.get(query("Failed")); code != http.StatusNoContent {
+		t.Errorf("expected bob to see none of alice's goals. got: %d", code)
+	}
+
+	//the goal table links each incomplete goal to its completion url
+	_, body, _ = c.get(query("Failed"))
+	match := regexp.MustCompile(`completeGoal\(\s*(\d+)\s*\)`).FindStringSubmatch(body)
+
+	if match == nil {
+		t.Fatalf("expected a complete button on the failed goal. got: %s", body)
+	}
+
+	complete_path := "/goals/" + match[1] + "/complete"
+
+	if code, _, _ := other.post(complete_path, url.Values{}); code != http.StatusNotFound {
+		t.Errorf("expected bob completing alice's goal to return %d. got: %d", http.StatusNotFound, code)
+	}
+
+	if code, _, _ := c.post(complete_path, url.Values{}); code != http.StatusOK {
+		t.Errorf("expected completing a goal to return %d. got: %d", http.StatusOK, code)
+	}
+
+	if code, _, _ := c.post(complete_path, url.Values{}); code != http.StatusNotFound {
+		t.Errorf("expected completing a goal twice to return %d. got: %d", http.StatusNotFound, code)
+	}
+
+	if code, _, _ := c.post("/goals/not-an-id/complete", url.Values{}); code != http.StatusBadRequest {
+		t.Errorf("expected a malformed goal id to return %d. got: %d", http.StatusBadRequest, code)
+	}
+
+	code, body, _ = c.get(query("Complete"))
+
+	if code != http.StatusOK || !strings.Contains(body, "past") || strings.Contains(body, "completeGoal(") {
+		t.Errorf("expected the past goal complete without a complete button. got: %d %s", code, body)
+	}
+}
+
+func testHandlerStats(t *testing.T, store Store) {
+	server := newTestServer(t, store, Config{})
+	c := newTestClient(t, server)
+
+	if code, _, header := c.get("/stats"); code != http.StatusSeeOther || header.Get("Location") != "/login" {
+		t.Errorf("expected stats to need a login. got: %d %s", code, header.Get("Location"))
+	}
+
+	c.register("alice", "password1")
+	c.login("alice", "password1")
+
+	form := url.Values{
+		"title": { "past", "future" },
+		"start": { "2024-01-01", "2024-01-01" },
+		"due": { "2024-01-10", "2024-03-01" },
+		"notes": { "", "" },
+	}
+
+	c.post("/goals", form)
+
+	params := url.Values{ "start": { "2024-01-01" }, "end": { "2024-12-31" }, "now": { "2024-02-01" } }
+	code, body, header := c.get("/stats?format=json&" + params.Encode())
+
+	var stats GoalStats
+
+	if err := json.Unmarshal([]byte(body), &stats); err != nil || header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected json stats. got: %d %s", code, body)
+	}
+
+	if stats.Total.Set != 2 || stats.Total.Failed != 1 || stats.Total.InProgress != 1 || len(stats.ByMonth) != 2 {
+		t.Errorf("unexpected stats: %s", body)
+	}
+
+	code, body, _ = c.get("/stats?" + params.Encode())
+
+	if code != http.StatusOK || !strings.Contains(body, "Completion rate") || !strings.Contains(body, "2024-03-01") {
+		t.Errorf("expected the stats page. got: %d %s", code, body)
+	}
+
+	if code, _, _ := c.get("/stats?start=2024-02-01&end=2024-01-01"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a reversed period to return %d. got: %d", http.StatusUnprocessableEntity, code)
+	}
+}
+
+func testHandlerInviteRegistration(t *testing.T, store Store) {
+	server := newTestServer(t, store, Config{
+		Registration: RegistrationConfig{ Mode: REGISTRATION_INVITE },
+	})
+
+	//seeded directly, as registration needs an invite
+	admin := User{ username: "admin", password: "password1" }
+	store.InsertUser(context.Background(), &admin, "")
+	store.PromoteAdmins(context.Background(), []string{ "admin" })
+
+	c := newTestClient(t, server)
+	c.login("admin", "password1")
+
+	code, body, _ := c.post("/admin/invites", url.Values{ "max_uses": { "1" } })
+
+	if code != http.StatusOK {
+		t.Fatalf("expected invite creation to return %d. got: %d %s", http.StatusOK, code, body)
+	}
+
+	invites, _ := store.GetInviteCodes(context.Background())
+
+	if len(invites) != 1 {
+		t.Fatalf("expected one invite code. got: %d", len(invites))
+	}
+
+	user := newTestClient(t, server)
+
+	register := func(username string, invite_code string) int {
+		form := url.Values{ "username": { username }, "password": { "password1" }, "invite_code": { invite_code } }
+		code, _, _ := user.post("/register", form)
+
+		return code
+	}
+
+	if code := register("carol", ""); code != http.StatusForbidden {
+		t.Errorf("expected register without invite to return %d. got: %d", http.StatusForbidden, code)
+	}
+
+	if code := register("carol", "not a code"); code != http.StatusForbidden {
+		t.Errorf("expected register with a bad invite to return %d. got: %d", http.StatusForbidden, code)
+	}
+
+	invite_code := inviteCodeFromPage(t, body)
+
+	if code := register("carol", invite_code); code != http.StatusCreated {
+		t.Errorf("expected register with invite to return %d. got: %d", http.StatusCreated, code)
+	}
+
+	if code := register("dave", invite_code); code != http.StatusForbidden {
+		t.Errorf("expected used up invite to return %d. got: %d", http.StatusForbidden, code)
+	}
+}
+
+//the admin page shows a newly created code once, straight after creating it
+func inviteCodeFromPage(t *testing.T, body string) string {
+	_, after, found := strings.Cut(body, "New invite code")
+
+	if !found {
+		t.Fatalf("new invite code not found on admin page")
+	}
+
+	_, after, _ = strings.Cut(after, "<code>")
+	code, _, _ := strings.Cut(after, "</code>")
+
+	return code
+}
+
+func testHandlerAdminDisableUser(t *testing.T, store Store) {
+	server := newTestServer(t, store, Config{})
+
+	admin := newTestClient(t, server)
+	admin.register("admin", "password1")
+	store.PromoteAdmins(context.Background(), []string{ "admin" })
+	admin.login("admin", "password1")
+
+	user := newTestClient(t, server)
+	user.register("alice", "password1")
+	user.login("alice", "password1")
+
+	if code, _, _ := user.get("/admin"); code != http.StatusForbidden {
+		t.Errorf("expected non admin to be refused the admin page. got: %d", code)
+	}
+
+	if code, _, _ := admin.post("/admin/users/alice/disable", url.Values{}); code != http.StatusSeeOther {
+		t.Fatalf("expected disable to redirect. got: %d", code)
+	}
+
+	if code, _, _ := user.get("/"); code != http.StatusSeeOther {
+		t.Errorf("expected disabled user's session to end. got: %d", code)
+	}
+
+	if code := user.login("alice", "password1"); code != http.StatusForbidden {
+		t.Errorf("expected disabled user login to return %d. got: %d", http.StatusForbidden, code)
+	}
+
+	if code, _, _ := admin.post("/admin/users/nobody/disable", url.Values{}); code != http.StatusNotFound {
+		t.Errorf("expected unknown user to return %d. got: %d", http.StatusNotFound, code)
+	}
+
+	code, body, _ := admin.get("/admin/audit?event_type=" + AUDIT_ADMIN_ACTION)
+
+	var events []AuditEvent
+	json.Unmarshal([]byte(body), &events)
+
+	if code != http.StatusOK || len(events) != 1 || events[0].Detail != "disable alice" {
+		t.Errorf("expected the disable to be audited. got: %d %s", code, body)
+	}
+}
+
+func testHandlerAccountExportAndDelete(t *testing.T, store Store) {
+	server := newTestServer(t, store, Config{})
+	c := newTestClient(t, server)
+
+	c.register("alice", "password1")
+	c.login("alice", "password1")
+	c.post("/goals", url.Values{ "title": { "goal" }, "start": { "2024-01-01" }, "due": { "2024-01-02" }, "tags": { "Work, health" } })
+
+	code, body, _ := c.get("/account/export")
+
+	var export AccountExport
+
+	if err := json.Unmarshal([]byte(body), &export); err != nil {
+		t.Fatalf("error decoding export: %d %s", code, err.Error())
+	}
+
+	if export.Account.Username != "alice" || len(export.Goals) != 1 || len(export.Sessions) != 1 {
+		t.Errorf("unexpected export contents: %s", body)
+	}
+
+	if len(export.Goals) == 1 && (export.Goals[0].StartDate != "2024-01-01" || export.Goals[0].EndDate != "2024-01-02") {
+		t.Errorf("expected goal dates to be kept. got: %+v", export.Goals[0])
+	}
+
+	if len(export.Goals) == 1 && !slices.Equal(export.Goals[0].Tags, []string{ "health", "work" }) {
+		t.Errorf("expected the goal's tags to be kept. got: %+v", export.Goals[0])
+	}
+
+	confirm := url.Values{ "password": { "password1" }, "confirm_username": { "alice" } }
+
+	if code, _, _ := c.post("/account/delete", confirm); code != http.StatusSeeOther {
+		t.Fatalf("expected account delete to redirect. got: %d", code)
+	}
+
+	if code := c.login("alice", "password1"); code != http.StatusUnauthorized {
+		t.Errorf("expected deleted user login to return %d. got: %d", http.StatusUnauthorized, code)
+	}
+
+	//the user's audit events outlive them, anonymised
+	since := time.Now().Add(-time.Minute)
+	events, _ := store.GetAuditEvents(context.Background(), &AuditFilter{ username: DELETED_USERNAME, since: &since, limit: AUDIT_DEFAULT_LIMIT })
+
+	if len(events) == 0 {
+		t.Error("expected audit events to be anonymised rather than deleted")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const READINESS_CHECK_TIMEOUT = 2 * time.Second
+
+const HEALTH_OK = "ok"
+const HEALTH_FAILED = "failed"
+
+//only used to work out which migration version the code expects,
+//postgres migrations are applied by hand
+//
+//go:embed db/migrations/*.sql
+var migration_files embed.FS
+
+const MIGRATIONS_DIR = "db/migrations"
+
+//set once graceful shutdown starts so load balancers stop sending traffic
+var shutting_down atomic.Bool
+
+type healthCheck struct {
+	name string
+	check func(ctx context.Context) error
+}
+
+//why a check failed is only logged, as /readyz is unauthenticated and the
+//error can hold connection details
+type HealthCheckResult struct {
+	Name string `json:"name"`
+	Status string `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+type ReadinessResponse struct {
+	Status string `json:"status"`
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+//the version prefix of a migration file, eg. 7 for 0007_schema_migration.sql
+func migrationVersion(name string) (int, error) {
+	prefix, _, _ := strings.Cut(path.Base(name), "_")
+	version, err := strconv.Atoi(prefix)
+
+	if err != nil {
+		return 0, fmt.Errorf("migration %s has no version prefix", name)
+	}
+
+	return version, nil
+}
+
+//the version of the newest migration in dir
+func latestMigrationVersion(files fs.FS, dir string) (int, error) {
+	entries, err := fs.ReadDir(files, dir)
+
+	if err != nil {
+		return 0, err
+	}
+
+	latest := 0
+
+	for _, entry := range entries {
+		version, err := migrationVersion(entry.Name())
+
+		if err != nil {
+			return 0, err
+		}
+
+		latest = max(latest, version)
+	}
+
+	return latest, nil
+}
+
+func readinessChecks(store Store) []healthCheck {
+	return []healthCheck{
+		{
+			name: "database",
+			check: func(ctx context.Context) error {
+				return store.Ping(ctx)
+			},
+		},
+		{
+			name: "migrations",
+			check: func(ctx context.Context) error {
+				expected, err := store.LatestMigrationVersion()
+
+				if err != nil {
+					return err
+				}
+
+				applied, err := store.GetSchemaVersion(ctx)
+
+				if err != nil {
+					return err
+				}
+
+				if applied < expected {
+					return fmt.Errorf("schema is at version %d, expected %d", applied, expected)
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "templates",
+			check: func(ctx context.Context) error {
+				if templates == nil {
+					return errors.New("templates not loaded")
+				}
+
+				return nil
+			},
+		},
+	}
+}
+
+//liveness only says the process is serving requests, it never checks
+//dependencies so a db outage doesn't get the process restarted
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+func handleReadyz(checks []healthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), READINESS_CHECK_TIMEOUT)
+		defer cancel()
+
+		response := ReadinessResponse{ Status: "ready", Checks: []HealthCheckResult{} }
+
+		for _, check := range checks {
+			start := time.Now()
+			err := check.check(ctx)
+
+			result := HealthCheckResult{
+				Name: check.name,
+				Status: HEALTH_OK,
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+
+			if err != nil {
+				requestLogger(r).Error(
+					"readiness check failed",
+					"check", check.name,
+					"err", err.Error(),
+					"response_code", http.StatusServiceUnavailable,
+				)
+
+				result.Status = HEALTH_FAILED
+				response.Status = "not ready"
+			}
+
+			response.Checks = append(response.Checks, result)
+		}
+
+		if shutting_down.Load() {
+			response.Status = "shutting down"
+		}
+
+		status_code := http.StatusOK
+
+		if response.Status != "ready" {
+			status_code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status_code)
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLatestMigrationVersion(t *testing.T) {
+	version, err := latestMigrationVersion(migration_files, MIGRATIONS_DIR)
+
+	if err != nil {
+		t.Fatalf("error reading migrations: %s", err.Error())
+	}
+
+	if version < 7 {
+		t.Errorf("expected at least version 7, got %d", version)
+	}
+}
+
+func readyzResponse(t *testing.T, checks []healthCheck) (int, ReadinessResponse) {
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	res := httptest.NewRecorder()
+
+	handleReadyz(checks)(res, req)
+
+	var body ReadinessResponse
+	err := json.NewDecoder(res.Body).Decode(&body)
+
+	if err != nil {
+		t.Fatalf("readyz returned invalid json: %s", err.Error())
+	}
+
+	return res.Code, body
+}
+
+func TestReadyz(t *testing.T) {
+	passing := healthCheck{ name: "passing", check: func(ctx context.Context) error { return nil } }
+	failing := healthCheck{ name: "failing", check: func(ctx context.Context) error { return errors.New("db down") } }
+
+	code, body := readyzResponse(t, []healthCheck{ passing })
+
+	if code != http.StatusOK || body.Status != "ready" || len(body.Checks) != 1 || body.Checks[0].Status != HEALTH_OK {
+		t.Errorf("expected ready, got %d %+v", code, body)
+	}
+
+	code, body = readyzResponse(t, []healthCheck{ passing, failing })
+
+	if code != http.StatusServiceUnavailable || body.Status != "not ready" {
+		t.Errorf("expected not ready, got %d %+v", code, body)
+	}
+
+	if body.Checks[1].Status != HEALTH_FAILED {
+		t.Errorf("failing check not reported: %+v", body.Checks[1])
+	}
+
+	//the error is logged but never sent to the unauthenticated caller
+	res := httptest.NewRecorder()
+	handleReadyz([]healthCheck{ failing })(res, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if strings.Contains(res.Body.String(), "db down") {
+		t.Errorf("expected the check error to be left out of the response. got: %s", res.Body.String())
+	}
+
+	shutting_down.Store(true)
+	defer shutting_down.Store(false)
+
+	code, body = readyzResponse(t, []healthCheck{ passing })
+
+	if code != http.StatusServiceUnavailable || body.Status != "shutting down" {
+		t.Errorf("expected not ready during shutdown, got %d %+v", code, body)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	res := httptest.NewRecorder()
+
+	handleHealthz(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, res.Code)
+	}
+}
+
+func TestReadinessChecksWithDB(t *testing.T) {
+	db := openTestDB(t)
+	templates = initialiseTemplates()
+
+	code, body := readyzResponse(t, readinessChecks(newPostgresStore(db)))
+
+	if code != http.StatusOK {
+		t.Errorf("expected ready against a migrated db, got %d %+v", code, body)
+	}
+}
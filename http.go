@@ -11,16 +11,47 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/codes"
 )
 
+//the width of User_.username
+const USERNAME_MAX_LEN = 100
+const GOAL_TAG_MAX_LEN = 50
+
 var templates *template.Template
 
 func handlePing(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("healthy"))
 }
 
+//splits a comma separated tags field, lowercasing each tag so it's the
+//same tag however it was typed
+func parseGoalTags(value string) ([]string, error) {
+	tags := []string{}
+
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+
+		if tag == "" || slices.Contains(tags, tag) {
+			continue
+		}
+
+		if utf8.RuneCountInString(tag) > GOAL_TAG_MAX_LEN {
+			return nil, fmt.Errorf("Tags must be %d characters or fewer", GOAL_TAG_MAX_LEN)
+		}
+
+		tags = append(tags, tag)
+	}
+
+	return tags, nil
+}
+
 func parseFormIntoGoals(form url.Values) (*[]GoalInsert, error) {
 	if len(form["title"]) == 0 {
 		return nil, errors.New("no goals")
@@ -80,25 +111,38 @@ func parseFormIntoGoals(form url.Values) (*[]GoalInsert, error) {
 			end_date = &end
 		}
 
+		tags := []string{}
+
+		if len(form["tags"]) > i {
+			parsed, err := parseGoalTags(form["tags"][i])
+
+			if err != nil {
+				return nil, err
+			}
+
+			tags = parsed
+		}
+
 		goals[i] = GoalInsert{
 			title: title,
 			start_date: start_date,
 			end_date: end_date,
 			notes: notes,
+			tags: tags,
 		}
 	}
 
 	return &goals, nil
 }
 
-func handleGoals(db *sql.DB) http.HandlerFunc {
+func handleGoals(store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := r.ParseForm()
 
 		if err != nil {
 			err_msg := "malformed form request"
 
-			slog.Error(
+			requestLogger(r).Error(
 				err_msg,
 				"err", err.Error(),
 				"response_code", http.StatusBadRequest,
@@ -110,7 +154,7 @@ func handleGoals(db *sql.DB) http.HandlerFunc {
 		goals, err := parseFormIntoGoals(r.PostForm)
 
 		if err != nil {
-			slog.Error(
+			requestLogger(r).Error(
 				"error parsing form into goals",
 				"err", err.Error(),
 				"response_code", http.StatusBadRequest,
@@ -122,26 +166,77 @@ func handleGoals(db *sql.DB) http.HandlerFunc {
 
 		username := r.Context().Value("username").(string)
 
-		err = InsertGoals(db, username, goals)
+		err = store.InsertGoals(r.Context(), username, goals)
 
 		if err != nil {
 			http.Error(w, "error posting goals", http.StatusInternalServerError)
 			return
 		}
 
+		goals_created_total.Add(float64(len(*goals)))
+
 		w.Write([]byte("OK"))
 	}
 }
 
-func generateLoginUserTemplate(username string) (*bytes.Buffer, error) {
-	user := struct{ Username string }{ Username: username }
+func handleGoalCompletePost(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+
+		if err != nil {
+			http.Error(w, "Malformed goal id", http.StatusBadRequest)
+			return
+		}
+
+		username := r.Context().Value("username").(string)
+		err = store.CompleteGoal(r.Context(), username, id)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Goal not found or already complete", http.StatusNotFound)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, "error completing goal", http.StatusInternalServerError)
+			return
+		}
+
+		goals_completed_total.Inc()
+
+		requestLogger(r).Info(
+			"completed goal",
+			"id", id,
+			"username", username,
+			"response_code", http.StatusOK,
+		)
+
+		w.Write([]byte("OK"))
+	}
+}
+
+type PageTemplate struct {
+	Username string
+	IsAdmin bool
+	CsrfToken string
+	OidcProviders []OidcProviderLink
+	RegistrationMode string
+	AllowedDomains []string
+}
+
+func generatePageTemplate(ctx context.Context, name string, data any) (*bytes.Buffer, error) {
+	_, span := tracer.Start(ctx, "template " + name)
+	defer span.End()
 
 	buf := bytes.Buffer{}
-	err := templates.ExecuteTemplate(&buf, "login.html", user)
+	err := templates.ExecuteTemplate(&buf, name, data)
 
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error executing template")
+
 		slog.Error(
-			"error executing login template",
+			"error executing page template",
+			"template", name,
 			"err", err.Error(),
 			"response_code", http.StatusInternalServerError,
 		)
@@ -152,62 +247,74 @@ func generateLoginUserTemplate(username string) (*bytes.Buffer, error) {
 	return &buf, nil
 }
 
-func handleLoginGet(w http.ResponseWriter, r *http.Request) {
-	if username, ok := r.URL.Query()["username"]; ok {
-		buf, err := generateLoginUserTemplate(username[0])
+func writePageTemplate(w http.ResponseWriter, r *http.Request, name string, data PageTemplate) {
+	data.CsrfToken = csrfTokenFromContext(r.Context())
+	writeTemplate(w, r, name, data)
+}
 
-		if err != nil {
-			http.Error(w, "unknown error", http.StatusInternalServerError)
-			return
-		}
+//for pages whose data embeds PageTemplate. the caller fills in CsrfToken
+func writeTemplate(w http.ResponseWriter, r *http.Request, name string, data any) {
+	buf, err := generatePageTemplate(r.Context(), name, data)
 
-		buf.WriteTo(w)
+	if err != nil {
+		http.Error(w, "unknown error", http.StatusInternalServerError)
 		return
 	}
 
-	content, err := os.ReadFile("public/login.html")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
 
-	if err != nil {
-		slog.Error(
-			"error reading public/login.html file",
-			"err", err.Error(),
-			"response_code", http.StatusInternalServerError,
-		)
+func handleLoginGet(oidc_links []OidcProviderLink) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		registration := liveConfig().Registration
 
-		http.Error(w, "unknown error", http.StatusInternalServerError)
-		return
+		writePageTemplate(w, r, "login.html", PageTemplate{
+			//username is passed after registering to prefill the form
+			Username: r.URL.Query().Get("username"),
+			OidcProviders: oidc_links,
+			RegistrationMode: registrationMode(registration),
+		})
 	}
-
-	w.Write(content)
 }
 
-func handleLogoutPost(db *sql.DB) http.HandlerFunc {
+func handleLogoutPost(store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		session_id, err := r.Cookie("session_id")
 
-		if err != nil {
-			slog.Error(
-				"error getting session_id",
-				"err", err.Error(),
-				"response_code", http.StatusInternalServerError,
-			)
-		}
+		if err == nil {
+			hash := sha256.Sum256([]byte(session_id.Value))
+			username, err := store.DeleteSessionId(r.Context(), hash)
 
-		hash := sha256.Sum256([]byte(session_id.String()))
-		err = DeleteSessionId(db, hash)
+			if err != nil {
+				err_msg := "unknown error"
 
-		if err != nil {
-			err_msg := "unknown error"
+				requestLogger(r).Error(
+					err_msg,
+					"err", err.Error(),
+					"response_code", http.StatusInternalServerError,
+				)
 
-			slog.Error(
-				err_msg,
-				"err", err.Error(),
-				"response_code", http.StatusInternalServerError,
-			)
+				http.Error(w, err_msg, http.StatusInternalServerError)
+				return
+			}
 
-			http.Error(w, err_msg, http.StatusInternalServerError)
-			return
+			if username != "" {
+				recordAuditEvent(store, r, AUDIT_LOGOUT, username, AUDIT_SUCCESS, "")
+			}
+		} else {
+			requestLogger(r).Info("logout without session_id cookie", "err", err.Error())
 		}
+
+		//always clear the cookie so the client isn't left holding a dead session
+		http.SetCookie(w, expiredSessionCookie())
+
+		requestLogger(r).Info(
+			"successfully logged out user",
+			"response_code", http.StatusSeeOther,
+		)
+
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	}
 }
 
@@ -245,8 +352,8 @@ func parseFormIntoUser(form url.Values) (*User, error) {
 	return &user, nil
 }
 
-func validateUserAgainstDB(db *sql.DB, user *User) (err_msg string, status_code int) {
-  db_user, err := GetUser(db, user.username)
+func validateUserAgainstDB(ctx context.Context, store Store, user *User) (err_msg string, status_code int) {
+  db_user, err := store.GetUser(ctx, user.username)
 
   if errors.Is(err, sql.ErrNoRows) {
     slog.Debug(
@@ -261,6 +368,16 @@ func validateUserAgainstDB(db *sql.DB, user *User) (err_msg string, status_code
     return "Error validating user", http.StatusInternalServerError
   }
 
+  if db_user.password == NO_PASSWORD_PARAMS {
+    slog.Debug(
+      "password login attempted for user without password",
+      "username", user.username,
+      "response_code", http.StatusUnauthorized,
+    )
+
+    return "Incorrect username or password", http.StatusUnauthorized
+  }
+
   match, err := comparePasswordWithHash(user.password, db_user.password)
 
   if err != nil {
@@ -283,17 +400,45 @@ func validateUserAgainstDB(db *sql.DB, user *User) (err_msg string, status_code
     return "Incorrect username or password", http.StatusUnauthorized
   }
 
+  //only checked after the password so disabled accounts can't be enumerated
+  if db_user.disabled {
+    slog.Info(
+      "login attempted for disabled user",
+      "username", user.username,
+      "response_code", http.StatusForbidden,
+    )
+
+    return "Account is disabled", http.StatusForbidden
+  }
+
+  rehash, err := needsRehash(db_user.password)
+
+  if err != nil {
+    slog.Error(
+      "error checking password params",
+      "username", user.username,
+      "err", err.Error(),
+    )
+  } else if rehash {
+    //failing to upgrade shouldn't fail the login, the old hash is still valid
+    err = store.UpdateUserPassword(ctx, user.username, hashPassword(user.password))
+
+    if err == nil {
+      slog.Info("upgraded password hash params", "username", user.username)
+    }
+  }
+
   return "", 0
 }
 
-func handleLoginPost(db *sql.DB) http.HandlerFunc {
+func handleLoginPost(store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err := r.ParseForm()
 
 		if err != nil {
 			err_msg := "malformed form request"
 
-			slog.Error(
+			requestLogger(r).Error(
 				err_msg,
 				"err", err.Error(),
 				"response_code", http.StatusBadRequest,
@@ -306,7 +451,7 @@ func handleLoginPost(db *sql.DB) http.HandlerFunc {
 		user, err := parseFormIntoUser(r.PostForm)
 
 		if err != nil {
-			slog.Error(
+			requestLogger(r).Error(
 				"error parsing form into user",
 				"err", err.Error(),
 				"response_code", http.StatusInternalServerError,
@@ -316,17 +461,18 @@ func handleLoginPost(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-      err_str, status_code := validateUserAgainstDB(db, user)
+      err_str, status_code := validateUserAgainstDB(r.Context(), store, user)
 
       if status_code != 0 {
+			recordAuditEvent(store, r, AUDIT_LOGIN, user.username, AUDIT_FAILURE, err_str)
 			http.Error(w, err_str, status_code)
 			return
       }
 
-		session_id, err := CreateUserSessionId(db, user.username)
+		session_id, err := CreateUserSessionId(r.Context(), store, user.username)
 
 		if err != nil {
-			slog.Error(
+			requestLogger(r).Error(
 				"error generating session id",
 				"username", user.username,
 				"response_code", http.StatusInternalServerError,
@@ -336,19 +482,25 @@ func handleLoginPost(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		slog.Info(
+		requestLogger(r).Info(
 			"successfully logged in user",
 			"username", user.username,
 			"response_code", http.StatusOK,
 		)
 
-		cookie_str := fmt.Sprintf("session_id=%s", session_id)
+		recordAuditEvent(store, r, AUDIT_LOGIN, user.username, AUDIT_SUCCESS, "password")
 
-		w.Header().Add("Set-Cookie", cookie_str)
+		http.SetCookie(w, newSessionCookie(session_id))
 		w.Write([]byte("OK"))
 	}
 }
 
+//deleted accounts' audit events and invites are reassigned to
+//DELETED_USERNAME, so whoever held it would see them
+func isReservedUsername(username string) bool {
+	return username == DELETED_USERNAME
+}
+
 func validateUserConstraints(user *User) string {
 	err_str := ""
 
@@ -357,6 +509,14 @@ func validateUserConstraints(user *User) string {
 		err_str = "Password must be 8 characters or longer"
 	}
 
+	if utf8.RuneCountInString(user.username) > USERNAME_MAX_LEN {
+		err_str = fmt.Sprintf("Username must be %d characters or fewer", USERNAME_MAX_LEN)
+	}
+
+	if isReservedUsername(user.username) {
+		err_str = "Username is reserved"
+	}
+
 	if err_str != "" {
 		slog.Debug(
 			"user violated constraints",
@@ -368,14 +528,15 @@ func validateUserConstraints(user *User) string {
 	return err_str
 }
 
-func handleRegisterPost(db *sql.DB) http.HandlerFunc {
+func handleRegisterPost(store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		registration := liveConfig().Registration
 		err := r.ParseForm()
 
 		if err != nil {
 			err_msg := "malformed form request"
 
-			slog.Error(
+			requestLogger(r).Error(
 				err_msg,
 				"err", err.Error(),
 				"response_code", http.StatusBadRequest,
@@ -388,7 +549,7 @@ func handleRegisterPost(db *sql.DB) http.HandlerFunc {
 		user, err := parseFormIntoUser(r.PostForm)
 
 		if err != nil {
-			slog.Error(
+			requestLogger(r).Error(
 				"error parsing form into user",
 				"err", err.Error(),
 				"response_code", http.StatusInternalServerError,
@@ -398,6 +559,21 @@ func handleRegisterPost(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		invite_code, err_msg, status_code := checkRegistrationForm(registration, r.PostForm, user)
+
+		if status_code != 0 {
+			requestLogger(r).Info(
+				"registration rejected",
+				"username", user.username,
+				"err", err_msg,
+				"response_code", status_code,
+			)
+
+			recordAuditEvent(store, r, AUDIT_REGISTER, user.username, AUDIT_FAILURE, err_msg)
+			http.Error(w, err_msg, status_code)
+			return
+		}
+
 		valid_user := validateUserConstraints(user)
 
 		if valid_user != "" {
@@ -405,10 +581,14 @@ func handleRegisterPost(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		pg_err := InsertUser(db, user)
+		err = store.InsertUser(r.Context(), user, invite_code)
 
-		if pg_err != nil {
-			if pg_err.pg_err.Code == "23505" {
+		if err != nil {
+			if errors.Is(err, ErrInvalidInviteCode) {
+				recordAuditEvent(store, r, AUDIT_REGISTER, user.username, AUDIT_FAILURE, "invalid invite code")
+				http.Error(w, "Invite code is invalid or has expired", http.StatusForbidden)
+			} else if errors.Is(err, ErrUsernameTaken) {
+				recordAuditEvent(store, r, AUDIT_REGISTER, user.username, AUDIT_FAILURE, "username already exists")
 				http.Error(w, "Username already exists", http.StatusConflict)
 			} else {
 				http.Error(w, "error creating user", http.StatusInternalServerError)
@@ -417,35 +597,21 @@ func handleRegisterPost(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		slog.Info(
+		requestLogger(r).Info(
 			"successfully created user",
 			"user", user.username,
 			"response_code", http.StatusCreated,
 		)
 
+		recordAuditEvent(store, r, AUDIT_REGISTER, user.username, AUDIT_SUCCESS, registrationMode(registration))
+
 		w.WriteHeader(http.StatusCreated)
 		w.Write([]byte("OK"))
 	}
 }
 
-func handleRegisterGet(w http.ResponseWriter, r *http.Request) {
-	content, err := os.ReadFile("public/register.html")
-
-	if err != nil {
-		slog.Error(
-			"error reading public/register.html file",
-			"err", err.Error(),
-			"response_code", http.StatusInternalServerError,
-		)
-
-		http.Error(w, "unknown error", http.StatusInternalServerError)
-		return
-	}
-
-	w.Write(content)
-}
-
 type GoalDisplay struct {
+	Id int64
 	Title string
 	Status string
 	Notes string
@@ -461,24 +627,30 @@ type GoalDisplayTemplate struct {
 }
 
 func handleHomePage(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "public/index.html")
+	username := r.Context().Value("username").(string)
+	is_admin := r.Context().Value("is_admin").(bool)
+
+	writePageTemplate(w, r, "index.html", PageTemplate{
+		Username: username,
+		IsAdmin: is_admin,
+	})
 }
 
-func authorisationMiddleware(next http.Handler, db *sql.DB) http.Handler {
+func authorisationMiddleware(next http.Handler, store Store) http.Handler {
 	handler_func := func(w http.ResponseWriter, r *http.Request) {
 		session_id, err := r.Cookie("session_id")
 
 		if err != nil {
-			slog.Info("session_id cookie not provided or in incorrect format", "response_code", http.StatusSeeOther)
+			requestLogger(r).Info("session_id cookie not provided or in incorrect format", "response_code", http.StatusSeeOther)
 			w.Header().Add("Location", "/login")
 			http.Error(w, "session_id cookie not provided", http.StatusSeeOther)
 			return
 		}
 
-		username, err := VerifyUser(db, session_id.Value)
+		username, is_admin, err := VerifyUser(r.Context(), store, session_id.Value)
 
 		if err != nil {
-			slog.Error(
+			requestLogger(r).Error(
 				"error verifying user session id",
 				"err", err.Error(),
 				"response_code", http.StatusInternalServerError,
@@ -486,13 +658,16 @@ func authorisationMiddleware(next http.Handler, db *sql.DB) http.Handler {
 		}
 
 		if username == "" {
-			slog.Info("session_id cookie doesn't exist or has expired", "response_code", http.StatusSeeOther)
+			requestLogger(r).Info("session_id cookie doesn't exist or has expired", "response_code", http.StatusSeeOther)
 			w.Header().Add("Location", "/login")
 			http.Error(w, "session_id cookie doesn't exist or has expired", http.StatusSeeOther)
 			return
 		}
 
+		requestInfoFromContext(r.Context()).username = username
+
 		ctx := context.WithValue(r.Context(), "username", username)
+		ctx = context.WithValue(ctx, "is_admin", is_admin)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 
@@ -500,11 +675,11 @@ func authorisationMiddleware(next http.Handler, db *sql.DB) http.Handler {
 }
 
 func initialiseTemplates() *template.Template {
-	templates, err := template.ParseFiles("templates/login.html")
+	templates, err := template.ParseGlob("templates/*.html")
 
 	if err != nil {
 		slog.Error(
-			"error parsing templates/login.html",
+			"error parsing templates/*.html",
 			"err", err.Error(),
 		)
 
@@ -525,6 +700,7 @@ func initialiseTemplates() *template.Template {
 	return templates
 }
 
+//goalFailedBefore is the same rule for sql, the two must agree
 func getGoalStatus(goal Goal, now *time.Time) (string, error) {
 	if goal.completed_datetime != nil {
 		return "Complete", nil
@@ -548,6 +724,7 @@ func goalsToDisplayGoals(goals []Goal) []GoalDisplay {
 
 	for i, goal := range goals {
 		goal_display[i] = GoalDisplay{
+			Id: goal.id,
 			Title: goal.title,
 			Status: goal.status,
 			Notes: goal.notes,
@@ -677,7 +854,7 @@ func filterGoalsByStatus(goals []Goal, now *time.Time, inprogress, complete, fai
 	return &filtered_goals, nil
 }
 
-func handleGoalsGet(db *sql.DB) http.HandlerFunc {
+func handleGoalsGet(store Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		err,
 		status_code,
@@ -695,15 +872,15 @@ func handleGoalsGet(db *sql.DB) http.HandlerFunc {
 
 		username := r.Context().Value("username").(string)
 
-		db_goals, err := GetGoals(
-			db,
+		db_goals, err := store.GetGoals(
+			r.Context(),
 			username,
 			start,
 			end,
 		)
 
 		if err != nil {
-			slog.Error(
+			requestLogger(r).Error(
 				"error retrieving goals",
 				"err", err.Error(),
 				"response_code", http.StatusInternalServerError,
@@ -713,16 +890,18 @@ func handleGoalsGet(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		slog.Debug(
+		requestLogger(r).Debug(
 			"total goal count for user",
 			"goals", len(db_goals),
 			"username", username,
 		)
 
+		_, filter_span := tracer.Start(r.Context(), "filterGoalsByStatus")
 		filtered_goals, err := filterGoalsByStatus(db_goals, now, inprogress, complete, failed)
+		filter_span.End()
 
 		if err != nil {
-			slog.Error(
+			requestLogger(r).Error(
 				"error filtering goals",
 				"err", err.Error(),
 				"response_code", http.StatusInternalServerError,
@@ -732,7 +911,7 @@ func handleGoalsGet(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		slog.Debug(
+		requestLogger(r).Debug(
 			"filtered goal count for user",
 			"goals", len(*filtered_goals),
 			"username", username,
@@ -751,8 +930,7 @@ func handleGoalsGet(db *sql.DB) http.HandlerFunc {
 			GoalDisplay: display_goals,
 		}
 
-		buf := bytes.Buffer{}
-		err = templates.ExecuteTemplate(&buf, "goal-table.html", template_data)
+		buf, err := generatePageTemplate(r.Context(), "goal-table.html", template_data)
 
 		if err != nil {
 			http.Error(w, "unknown error", http.StatusInternalServerError)
@@ -763,7 +941,7 @@ func handleGoalsGet(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func initialiseHTTPServer(db *sql.DB) *http.ServeMux {
+func initialiseHTTPServer(store Store, conf *Config) http.Handler {
 	mux := http.NewServeMux()
 
 	templates = initialiseTemplates()
@@ -772,22 +950,56 @@ func initialiseHTTPServer(db *sql.DB) *http.ServeMux {
 		return nil
 	}
 
-	home_handler := authorisationMiddleware(http.HandlerFunc(handleHomePage), db)
-	goals_post_handler := authorisationMiddleware(handleGoals(db), db)
-	goals_get_handler := authorisationMiddleware(handleGoalsGet(db), db)
-	logout_post_handler := authorisationMiddleware(handleLogoutPost(db), db)
+	oidc_providers := initialiseOidcProviders(conf.Oidc_providers)
+	oidc_links := oidcProviderLinks(oidc_providers, conf.Oidc_providers)
+
+	home_handler := authorisationMiddleware(http.HandlerFunc(handleHomePage), store)
+	goals_post_handler := authorisationMiddleware(handleGoals(store), store)
+	goals_get_handler := authorisationMiddleware(handleGoalsGet(store), store)
+	goal_complete_handler := authorisationMiddleware(handleGoalCompletePost(store), store)
+	stats_get_handler := authorisationMiddleware(handleStatsGet(store), store)
+	account_get_handler := authorisationMiddleware(handleAccountGet(store), store)
+	account_export_handler := authorisationMiddleware(handleAccountExport(store), store)
+	account_delete_handler := authorisationMiddleware(handleAccountDeletePost(store), store)
+	account_password_handler := authorisationMiddleware(handleAccountPasswordPost(store), store)
+	admin_get_handler := authorisationMiddleware(adminMiddleware(handleAdminGet(store)), store)
+	admin_user_action_handler := authorisationMiddleware(adminMiddleware(handleAdminUserAction(store)), store)
+	admin_invite_post_handler := authorisationMiddleware(adminMiddleware(handleAdminInvitePost(store)), store)
+	admin_invite_revoke_handler := authorisationMiddleware(adminMiddleware(handleAdminInviteRevoke(store)), store)
+	admin_audit_handler := authorisationMiddleware(adminMiddleware(handleAdminAuditGet(store)), store)
 
 	mux.Handle("GET /", http.FileServer(http.Dir("./public")))
 	mux.Handle("GET /{$}", home_handler)
 	mux.Handle("GET /goals", goals_get_handler)
 	mux.Handle("POST /goals", goals_post_handler)
-	mux.Handle("POST /logout", logout_post_handler)
+	mux.Handle("POST /goals/{id}/complete", goal_complete_handler)
+	mux.Handle("GET /stats", stats_get_handler)
+	//not behind authorisationMiddleware so stale cookies can still be cleared
+	mux.HandleFunc("POST /logout", handleLogoutPost(store))
+	mux.Handle("GET /account", account_get_handler)
+	mux.Handle("GET /account/export", account_export_handler)
+	mux.Handle("POST /account/delete", account_delete_handler)
+	mux.Handle("POST /account/password", account_password_handler)
+	mux.Handle("GET /admin", admin_get_handler)
+	mux.Handle("POST /admin/users/{username}/{action}", admin_user_action_handler)
+	mux.Handle("POST /admin/invites", admin_invite_post_handler)
+	mux.Handle("POST /admin/invites/{id}/revoke", admin_invite_revoke_handler)
+	mux.Handle("GET /admin/audit", admin_audit_handler)
 	mux.HandleFunc("GET /ping", handlePing)
-	mux.HandleFunc("GET /login", handleLoginGet)
-	mux.HandleFunc("POST /login", handleLoginPost(db))
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz(readinessChecks(store)))
+	mux.HandleFunc("GET /login", handleLoginGet(oidc_links))
+	mux.HandleFunc("GET /login/oidc/{provider}", handleOidcLogin(oidc_providers))
+	mux.HandleFunc("GET /login/oidc/{provider}/callback", handleOidcCallback(store, oidc_providers))
+	mux.HandleFunc("POST /login", handleLoginPost(store))
 	mux.HandleFunc("GET /register", handleRegisterGet)
-	mux.HandleFunc("POST /register", handleRegisterPost(db))
+	mux.HandleFunc("POST /register", handleRegisterPost(store))
+
+	//otherwise served on its own listener, see main
+	if conf.Metrics.Port == 0 {
+		mux.Handle("GET /metrics", handleMetrics())
+	}
 
-	return mux
+	return tracingMiddleware(requestLoggingMiddleware(csrfMiddleware(metricsMiddleware(mux))))
 }
 
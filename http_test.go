@@ -1,10 +1,36 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
 
+func TestParseGoalTags(t *testing.T) {
+	tags, err := parseGoalTags(" Work, health,,work ,  ")
+
+	if err != nil || !slices.Equal(tags, []string{ "work", "health" }) {
+		t.Errorf("expected [work health]. got: %v %v", tags, err)
+	}
+
+	if tags, _ := parseGoalTags(""); len(tags) != 0 {
+		t.Errorf("expected no tags from an empty field. got: %v", tags)
+	}
+
+	if _, err := parseGoalTags(strings.Repeat("ü", GOAL_TAG_MAX_LEN + 1)); err == nil {
+		t.Error("expected an error for an overlong tag")
+	}
+}
+
 func TestGoalStatus(t *testing.T) {
 	now := time.Now()
 	now_date_str := now.Format(time.DateOnly)
@@ -39,3 +65,316 @@ func TestGoalStatus(t *testing.T) {
 	}
 }
 
+
+func TestCsrfMiddleware(t *testing.T) {
+	handler := csrfMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(csrfTokenFromContext(r.Context())))
+	}))
+
+	//a GET without a cookie should be issued a new token
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+
+	cookies := rec.Result().Cookies()
+
+	if len(cookies) != 1 || cookies[0].Name != CSRF_COOKIE_NAME {
+		t.Fatalf("expected csrf cookie to be set. got: %v", cookies)
+	}
+
+	cookie := cookies[0]
+
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("csrf cookie missing secure attributes. got: %s", cookie.String())
+	}
+
+	if rec.Body.String() != cookie.Value {
+		t.Errorf("token in context does not match cookie. expected: %s, got: %s", cookie.Value, rec.Body.String())
+	}
+
+	//a POST without the token should be rejected
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %d without token. got: %d", http.StatusForbidden, rec.Code)
+	}
+
+	//a POST with a mismatched form token should be rejected
+	form := url.Values{ CSRF_FORM_FIELD: { "mismatched" } }
+	req = httptest.NewRequest(http.MethodPost, "/goals", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %d with mismatched token. got: %d", http.StatusForbidden, rec.Code)
+	}
+
+	//a POST with the token in the form should pass
+	form = url.Values{ CSRF_FORM_FIELD: { cookie.Value } }
+	req = httptest.NewRequest(http.MethodPost, "/goals", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d with form token. got: %d", http.StatusOK, rec.Code)
+	}
+
+	//a POST with the token in the header should pass
+	req = httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.Header.Set(CSRF_HEADER, cookie.Value)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d with header token. got: %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	cookie := newSessionCookie("abc")
+
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("session cookie missing secure attributes. got: %s", cookie.String())
+	}
+
+	if cookie.Path != "/" || cookie.MaxAge != DEFAULT_SESSION_MAX_AGE {
+		t.Errorf("session cookie has unexpected path or max age. got: %s", cookie.String())
+	}
+}
+
+func TestLogoutDeletesSession(t *testing.T) {
+	db := openTestDB(t)
+
+	user := User{ username: "logout_test_user", password: "password" }
+
+	if pg_err := InsertUser(context.Background(), db, &user, ""); pg_err != nil {
+		t.Fatalf("error inserting test user: %s", pg_err.err.Error())
+	}
+
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM SessionId WHERE username = $1", user.username)
+		db.Exec("DELETE FROM User_ WHERE username = $1", user.username)
+	})
+
+	store := newPostgresStore(db)
+	session_id, err := CreateUserSessionId(context.Background(), store, user.username)
+
+	if err != nil {
+		t.Fatalf("error creating session id: %s", err.Error())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(newSessionCookie(session_id))
+	rec := httptest.NewRecorder()
+
+	handleLogoutPost(store).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("expected redirect to /login. got: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+
+	cookies := rec.Result().Cookies()
+
+	if len(cookies) != 1 || cookies[0].Name != "session_id" || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected expired session_id cookie. got: %v", cookies)
+	}
+
+	username, _, err := VerifyUser(context.Background(), store, session_id)
+
+	if err != nil {
+		t.Errorf("error verifying user: %s", err.Error())
+	} else if username != "" {
+		t.Error("session should not be valid after logout")
+	}
+
+	var count int
+	hash := sha256.Sum256([]byte(session_id))
+	db.QueryRow("SELECT COUNT(*) FROM SessionId WHERE session_id_sha256 = $1", hash[:]).Scan(&count)
+
+	if count != 0 {
+		t.Errorf("expected session row to be deleted. found %d", count)
+	}
+}
+
+func TestLogoutWithoutCookie(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	rec := httptest.NewRecorder()
+
+	//db is never touched without a cookie
+	handleLogoutPost(nil).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("expected redirect to /login. got: %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+
+	cookies := rec.Result().Cookies()
+
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("expected expired session_id cookie. got: %v", cookies)
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	handler := adminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	for _, is_admin := range []bool{ false, true } {
+		req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		ctx := context.WithValue(req.Context(), "username", "user")
+		ctx = context.WithValue(ctx, "is_admin", is_admin)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req.WithContext(ctx))
+
+		expected := http.StatusForbidden
+
+		if is_admin {
+			expected = http.StatusOK
+		}
+
+		if rec.Code != expected {
+			t.Errorf("is_admin %t: expected status %d. got: %d", is_admin, expected, rec.Code)
+		}
+	}
+
+	//no is_admin on context, ie. not behind authorisationMiddleware
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin", nil))
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status %d without auth. got: %d", http.StatusForbidden, rec.Code)
+	}
+}
+
+func TestAdminCannotDeleteSelf(t *testing.T) {
+	for _, action := range []string{ "disable", "delete" } {
+		req := httptest.NewRequest(http.MethodPost, "/admin/users/admin/" + action, nil)
+		req.SetPathValue("username", "admin")
+		req.SetPathValue("action", action)
+		ctx := context.WithValue(req.Context(), "username", "admin")
+		rec := httptest.NewRecorder()
+
+		//db is never touched when acting on yourself
+		handleAdminUserAction(nil).ServeHTTP(rec, req.WithContext(ctx))
+
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status %d. got: %d", action, http.StatusUnprocessableEntity, rec.Code)
+		}
+	}
+}
+
+func TestPageTemplatesRender(t *testing.T) {
+	templates = initialiseTemplates()
+
+	if templates == nil {
+		t.Fatal("error initialising templates")
+	}
+
+	pages := map[string]any{
+		"login.html": PageTemplate{
+			Username: "user",
+			OidcProviders: []OidcProviderLink{ { Name: "corp", DisplayName: "Corp" } },
+			RegistrationMode: REGISTRATION_CLOSED,
+		},
+		"register.html": PageTemplate{
+			RegistrationMode: REGISTRATION_DOMAIN,
+			AllowedDomains: []string{ "example.com" },
+		},
+		"index.html": PageTemplate{ Username: "user", IsAdmin: true },
+		"account.html": AccountTemplate{
+			PageTemplate: PageTemplate{ Username: "user" },
+			Events: []AuditEvent{ { EventType: AUDIT_LOGIN, Outcome: AUDIT_SUCCESS } },
+		},
+		"admin.html": AdminTemplate{
+			Users: []UserSummary{ { Username: "user", GoalCount: 2 } },
+			Invites: []InviteCodeSummary{ { Id: 1, CreatedBy: "admin", MaxUses: 1 } },
+			NewInviteCode: "code",
+		},
+	}
+
+	for name, data := range pages {
+		_, err := generatePageTemplate(context.Background(), name, data)
+
+		if err != nil {
+			t.Errorf("error rendering %s: %s", name, err.Error())
+		}
+	}
+}
+
+func TestAccountExportAndDelete(t *testing.T) {
+	db := openTestDB(t)
+
+	user := User{ username: "delete_test_user", password: "password" }
+
+	if pg_err := InsertUser(context.Background(), db, &user, ""); pg_err != nil {
+		t.Fatalf("error inserting test user: %s", pg_err.err.Error())
+	}
+
+	t.Cleanup(func() { db.Exec("DELETE FROM User_ WHERE username = $1", user.username) })
+
+	now := time.Now()
+	goals := []GoalInsert{ { title: "goal", start_date: &now, end_date: &now } }
+
+	if err := InsertGoals(context.Background(), db, user.username, &goals); err != nil {
+		t.Fatalf("error inserting goals: %s", err.Error())
+	}
+
+	store := newPostgresStore(db)
+
+	if _, err := CreateUserSessionId(context.Background(), store, user.username); err != nil {
+		t.Fatalf("error creating session: %s", err.Error())
+	}
+
+	ctx := context.WithValue(context.Background(), "username", user.username)
+
+	rec := httptest.NewRecorder()
+	handleAccountExport(store).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/account/export", nil).WithContext(ctx))
+
+	var export AccountExport
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &export); err != nil {
+		t.Fatalf("error decoding export: %s", err.Error())
+	}
+
+	if export.Account.Username != user.username || len(export.Goals) != 1 || len(export.Sessions) != 1 {
+		t.Errorf("unexpected export contents: %s", rec.Body.String())
+	}
+
+	deleteRequest := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{ "password": { password } }
+		req := httptest.NewRequest(http.MethodPost, "/account/delete", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+
+		handleAccountDeletePost(store).ServeHTTP(rec, req.WithContext(ctx))
+
+		return rec
+	}
+
+	if rec := deleteRequest("wrong password"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d with wrong password. got: %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	if rec := deleteRequest(user.password); rec.Code != http.StatusSeeOther {
+		t.Errorf("expected status %d with password. got: %d", http.StatusSeeOther, rec.Code)
+	}
+
+	if _, err := GetUser(context.Background(), db, user.username); !errors.Is(err, sql.ErrNoRows) {
+		t.Error("expected user to be deleted")
+	}
+
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM Goal WHERE username = $1", user.username).Scan(&count)
+
+	if count != 0 {
+		t.Errorf("expected goals to be deleted. found %d", count)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+const REQUEST_ID_LEN_BYTE = 8
+const REQUEST_ID_MAX_LEN = 128
+
+const LOG_FORMAT_TEXT = "text"
+const LOG_FORMAT_JSON = "json"
+
+type LogConfig struct {
+	//debug, info, warn or error. defaults to info
+	Level  string `json:"level"`
+	//text or json. defaults to text
+	Format string `json:"format"`
+}
+
+//filled in as the request passes through the middlewares further in,
+//so the request log line can include what they worked out
+type requestInfo struct {
+	route string
+	username string
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("%s is not one of debug, info, warn, error", level)
+	}
+}
+
+func validateLogConfig(conf *LogConfig) []error {
+	violations := []error{}
+
+	if _, err := parseLogLevel(conf.Level); err != nil {
+		violations = append(violations, schemaViolation{ pointer: "/log/level", message: err.Error() })
+	}
+
+	switch conf.Format {
+	case "", LOG_FORMAT_TEXT, LOG_FORMAT_JSON:
+	default:
+		violations = append(violations, schemaViolation{
+			pointer: "/log/format",
+			message: conf.Format + " is not one of text, json",
+		})
+	}
+
+	return violations
+}
+
+func newLogHandler(conf *LogConfig, out io.Writer) slog.Handler {
+	//validateConfig has already rejected unknown levels
+	level, _ := parseLogLevel(conf.Level)
+	options := slog.HandlerOptions{ Level: level }
+
+	if conf.Format == LOG_FORMAT_JSON {
+		return newRedactingHandler(slog.NewJSONHandler(out, &options))
+	}
+
+	return newRedactingHandler(slog.NewTextHandler(out, &options))
+}
+
+//replaces the default logger. loggers already handed out to in flight
+//requests keep the old settings until those requests finish
+func applyLogConfig(conf *LogConfig) {
+	slog.SetDefault(slog.New(newLogHandler(conf, os.Stderr)))
+}
+
+//incoming ids are only trusted if they're short and printable, anything
+//else gets replaced so it can't be used to inject into the logs
+func validRequestId(id string) bool {
+	if id == "" || len(id) > REQUEST_ID_MAX_LEN {
+		return false
+	}
+
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+//the logger for the request, tagged with its request id. falls back to
+//the default logger outside of requestLoggingMiddleware
+func requestLogger(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value("logger").(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value("request_info").(*requestInfo); ok {
+		return info
+	}
+
+	//a throwaway so callers outside the middleware needn't check for nil
+	return &requestInfo{}
+}
+
+//wraps the response writer to capture the status code and body size
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status_code int
+	bytes int
+}
+
+func (l *loggingResponseWriter) WriteHeader(status_code int) {
+	l.status_code = status_code
+	l.ResponseWriter.WriteHeader(status_code)
+}
+
+func (l *loggingResponseWriter) Write(b []byte) (int, error) {
+	n, err := l.ResponseWriter.Write(b)
+	l.bytes += n
+
+	return n, err
+}
+
+func (l *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
+
+//outermost middleware. assigns the request id, or keeps the one sent by
+//a proxy in X-Request-ID, and logs one line per request once it's done
+func requestLoggingMiddleware(next http.Handler) http.Handler {
+	handler_func := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		request_id := r.Header.Get(REQUEST_ID_HEADER)
+
+		if !validRequestId(request_id) {
+			var err error
+			request_id, err = generateSessionId(REQUEST_ID_LEN_BYTE)
+
+			if err != nil {
+				slog.Error("error generating request id", "err", err.Error())
+			}
+		}
+
+		w.Header().Set(REQUEST_ID_HEADER, request_id)
+
+		logger := slog.Default().With("request_id", request_id)
+
+		if span_context := trace.SpanContextFromContext(r.Context()); span_context.IsValid() {
+			logger = logger.With("trace_id", span_context.TraceID().String())
+		}
+		info := &requestInfo{}
+
+		ctx := context.WithValue(r.Context(), "logger", logger)
+		ctx = context.WithValue(ctx, "request_info", info)
+
+		writer := &loggingResponseWriter{ ResponseWriter: w, status_code: http.StatusOK }
+		next.ServeHTTP(writer, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+
+		if writer.status_code >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		logger.Log(
+			r.Context(),
+			level,
+			"request handled",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", info.route,
+			"status", writer.status_code,
+			"bytes", writer.bytes,
+			"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"username", info.username,
+		)
+	}
+
+	return http.HandlerFunc(handler_func)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//swaps the default logger for one writing json to the returned buffer
+func captureLogs(t *testing.T) *bytes.Buffer {
+	buf := bytes.Buffer{}
+	previous := slog.Default()
+
+	slog.SetDefault(slog.New(newLogHandler(&LogConfig{ Level: "debug", Format: LOG_FORMAT_JSON }, &buf)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	return &buf
+}
+
+func findLogLine(t *testing.T, buf *bytes.Buffer, msg string) map[string]any {
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+
+		if json.Unmarshal([]byte(line), &entry) == nil && entry["msg"] == msg {
+			return entry
+		}
+	}
+
+	t.Fatalf("no %q log line in:\n%s", msg, buf.String())
+	return nil
+}
+
+func TestRequestLoggingMiddleware(t *testing.T) {
+	buf := captureLogs(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /goals/{id}", func(w http.ResponseWriter, r *http.Request) {
+		requestInfoFromContext(r.Context()).username = "user"
+		requestLogger(r).Info("inside handler")
+		w.Write([]byte("hello"))
+	})
+
+	handler := requestLoggingMiddleware(metricsMiddleware(mux))
+
+	req := httptest.NewRequest(http.MethodGet, "/goals/12", nil)
+	req.Header.Set(REQUEST_ID_HEADER, "proxy-id-1")
+	res := httptest.NewRecorder()
+
+	handler.ServeHTTP(res, req)
+
+	if res.Header().Get(REQUEST_ID_HEADER) != "proxy-id-1" {
+		t.Errorf("expected request id to be propagated, got %q", res.Header().Get(REQUEST_ID_HEADER))
+	}
+
+	inner := findLogLine(t, buf, "inside handler")
+
+	if inner["request_id"] != "proxy-id-1" {
+		t.Errorf("request logger not tagged with request id: %v", inner)
+	}
+
+	entry := findLogLine(t, buf, "request handled")
+
+	expected := map[string]any{
+		"request_id": "proxy-id-1",
+		"method": "GET",
+		"route": "GET /goals/{id}",
+		"status": float64(200),
+		"bytes": float64(5),
+		"username": "user",
+	}
+
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("expected %s=%v, got %v", key, value, entry[key])
+		}
+	}
+}
+
+func TestRequestIdReplacedWhenInvalid(t *testing.T) {
+	captureLogs(t)
+
+	handler := requestLoggingMiddleware(http.HandlerFunc(handlePing))
+
+	for _, sent := range []string{ "", "has spaces\nand newlines", strings.Repeat("a", REQUEST_ID_MAX_LEN + 1) } {
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		req.Header.Set(REQUEST_ID_HEADER, sent)
+		res := httptest.NewRecorder()
+
+		handler.ServeHTTP(res, req)
+
+		id := res.Header().Get(REQUEST_ID_HEADER)
+
+		if id == sent || len(id) != REQUEST_ID_LEN_BYTE * 2 {
+			t.Errorf("expected a generated request id in place of %q, got %q", sent, id)
+		}
+	}
+}
+
+func TestValidateLogConfig(t *testing.T) {
+	valid := []LogConfig{ {}, { Level: "debug", Format: LOG_FORMAT_JSON }, { Level: "error", Format: LOG_FORMAT_TEXT } }
+
+	for _, conf := range valid {
+		if violations := validateLogConfig(&conf); len(violations) != 0 {
+			t.Errorf("expected %+v to be valid, got %v", conf, violations)
+		}
+	}
+
+	invalid := []LogConfig{ { Level: "verbose" }, { Format: "xml" } }
+
+	for _, conf := range invalid {
+		if violations := validateLogConfig(&conf); len(violations) == 0 {
+			t.Errorf("expected %+v to be invalid", conf)
+		}
+	}
+}
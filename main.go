@@ -1,42 +1,115 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 )
 
-func initialiseDBConn(
-	host string,
-	port uint16,
-	database_name string,
-	username string,
-	password string,
-) (*sql.DB, error) {
-	db, err := OpenDB(host, port, database_name, username, password)
+func initialiseDBConn(ctx context.Context, conf *DbConfig) (*sql.DB, error) {
+	db, err := OpenDB(conf)
 
 	if err != nil {
 		slog.Error("could not initialise db conn: " + err.Error())
 		return nil, err
 	}
 
+	applyDBPoolConfig(db, conf)
+
+	attempts := DEFAULT_DB_STARTUP_ATTEMPTS
+
+	if conf.Startup_attempts != 0 {
+		attempts = int(conf.Startup_attempts)
+	}
+
+	//postgres often comes up after the app under docker compose or kubernetes
+	err = retryWithBackoff(ctx, "pinging db", attempts, DB_STARTUP_BACKOFF_BASE, DB_STARTUP_BACKOFF_MAX, func(ctx context.Context) error {
+		ping_ctx, cancel := context.WithTimeout(ctx, DB_PING_TIMEOUT)
+		defer cancel()
+
+		return db.PingContext(ping_ctx)
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	slog.Info("db init success")
 
-	err = db.Ping()
+	return db, nil
+}
+
+//opens the sqlite file and brings its schema up to date
+func initialiseSqliteDB(ctx context.Context, conf *DbConfig) (*sql.DB, error) {
+	db, err := OpenSqliteDB(conf.Path)
 
 	if err != nil {
-		slog.Error("error pinging db: " + err.Error())
+		slog.Error("could not open sqlite db: " + err.Error())
 		return nil, err
 	}
 
+	applyDBPoolConfig(db, conf)
+
+	err = applySqliteMigrations(ctx, db)
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	slog.Info("sqlite db init success", "path", conf.Path)
+
 	return db, nil
 }
 
-func initialiseConfig() (*Config, error) {
-	conf, err := parseConfig("config.json")
+//the db is returned as well as the store for closing and pool metrics
+func initialiseStore(ctx context.Context, conf *DbConfig) (Store, *sql.DB, error) {
+	if conf.Driver == DB_DRIVER_SQLITE {
+		db, err := initialiseSqliteDB(ctx, conf)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return newSqliteStore(db), db, nil
+	}
+
+	db, err := initialiseDBConn(ctx, conf)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newPostgresStore(db), db, nil
+}
+
+func initialiseConfig(flag_path string) (*Config, error) {
+	path, explicit := resolveConfigPath(flag_path, os.LookupEnv)
+	conf, err := parseConfig(path)
+
+	//without an explicit path the file is optional so config can come from env alone
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		slog.Info("no config file found, using environment only")
+		conf, err = &Config{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = applyEnvOverrides(conf, os.LookupEnv)
 
 	if err != nil {
+		slog.Error("error applying environment overrides: " + err.Error())
 		return nil, err
 	}
 
@@ -50,41 +123,125 @@ func initialiseConfig() (*Config, error) {
 }
 
 func main(){
-	slog.SetLogLoggerLevel(slog.LevelDebug)
+	config_path := flag.String("config", "", "path to config.json, defaults to $" + CONFIG_PATH_ENV + " then ./" + DEFAULT_CONFIG_PATH)
+	flag.Parse()
+
+	//redacting defaults until the configured log settings are applied
+	applyLogConfig(&LogConfig{})
 
-	conf, err := initialiseConfig()
+	err := run(*config_path)
 
+	//deferred cleanup in run has finished by now, so exiting skips nothing
 	if err != nil {
-		return
+		slog.Error("exiting after fatal error: " + err.Error())
+		os.Exit(1)
 	}
 
-	db, err := initialiseDBConn(
-		conf.Db.Host,
-		conf.Db.Port,
-		conf.Db.Database_name,
-		conf.Db.Username,
-		conf.Db.Password,
-	)
+	slog.Info("shutdown complete")
+}
+
+//starts the app and serves until shutdown. any error returned is fatal
+func run(config_path string) error {
+	conf, err := initialiseConfig(config_path)
+
+	if err != nil {
+		return err
+	}
+
+	err = applyPasswordConfig(conf)
 
 	if err != nil {
-		return
+		return err
+	}
+
+	//cancelled on SIGINT or SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := initialiseTracing(ctx, &conf.Tracing)
+
+	if err != nil {
+		return err
+	}
+
+	//runs after the cleanup deferred below, so it flushes spans from
+	//everything that shuts down
+	defer func() {
+		flush_ctx, cancel := context.WithTimeout(context.Background(), secondsOrDefault(conf.Server.Shutdown_timeout, DEFAULT_SHUTDOWN_TIMEOUT))
+		defer cancel()
+
+		if err := shutdownTracing(flush_ctx); err != nil {
+			slog.Error("error flushing traces", "err", err.Error())
+		}
+	}()
+
+	storeLiveConfig(conf)
+
+	store, db, err := initialiseStore(ctx, &conf.Db)
+
+	if err != nil {
+		return err
 	}
 
 	defer db.Close()
 
-	mux := initialiseHTTPServer(db)
+	var jobs sync.WaitGroup
+	watchForConfigReload(ctx, &jobs, config_path)
+
+	//background jobs may still be using the db so they're stopped before
+	//the deferred db.Close runs
+	defer func() {
+		stop()
+		jobs.Wait()
+	}()
 
-	if mux == nil {
-		return
+	registerDBMetrics(db)
+
+	if len(conf.Admin_usernames) != 0 {
+		err = store.PromoteAdmins(ctx, conf.Admin_usernames)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	handler := initialiseHTTPServer(store, conf)
+
+	if handler == nil {
+		return errors.New("error initialising http server")
 	}
 
 	http_str := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
-	err = http.ListenAndServe(http_str, mux)
+	server := newHTTPServer(http_str, &conf.Server, handler)
+	servers := []*http.Server{ server }
 
-	if err != nil {
-		slog.Error(err.Error())
+	if tlsEnabled(&conf.Tls) {
+		server.TLSConfig, err = initialiseTlsConfig(&conf.Tls)
+
+		if err != nil {
+			return err
+		}
+
+		if conf.Tls.Redirect_port != 0 {
+			redirect_str := fmt.Sprintf("%s:%d", conf.Host, conf.Tls.Redirect_port)
+			servers = append(servers, newHTTPServer(redirect_str, &conf.Server, handleHttpsRedirect(conf.Port)))
+		}
 	}
 
-	return
+	if conf.Metrics.Port != 0 {
+		metrics_mux := http.NewServeMux()
+		metrics_mux.Handle("GET /metrics", handleMetrics())
+
+		metrics_str := fmt.Sprintf("%s:%d", conf.Host, conf.Metrics.Port)
+		servers = append(servers, newHTTPServer(metrics_str, &conf.Server, metrics_mux))
+	}
+
+	//logged by main once the deferred cleanup above has run
+	return serveUntilShutdown(
+		ctx,
+		servers,
+		secondsOrDefault(conf.Server.Shutdown_delay, DEFAULT_SHUTDOWN_DELAY),
+		secondsOrDefault(conf.Server.Shutdown_timeout, DEFAULT_SHUTDOWN_TIMEOUT),
+	)
 }
 
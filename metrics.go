@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const METRICS_NAMESPACE = "goal"
+
+type MetricsConfig struct {
+	//serves /metrics on its own listener instead of the main one so it
+	//can be kept off the public network. 0 serves it alongside the app
+	Port uint16 `json:"port"`
+}
+
+//a registry of our own rather than the global default so only the
+//metrics below, and the go and process collectors, are exposed
+var metrics_registry = prometheus.NewRegistry()
+
+var http_requests_total = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by route pattern and response code.",
+	},
+	[]string{ "method", "route", "code" },
+)
+
+var http_request_duration_seconds = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "http_request_duration_seconds",
+		Help: "Time taken to handle HTTP requests, by route pattern.",
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{ "method", "route" },
+)
+
+var logins_total = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "logins_total",
+		Help: "Login attempts, by outcome.",
+	},
+	[]string{ "outcome" },
+)
+
+var goals_created_total = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "goals_created_total",
+		Help: "Goals created.",
+	},
+)
+
+var goals_completed_total = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "goals_completed_total",
+		Help: "Goals marked complete.",
+	},
+)
+
+var background_jobs_total = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name: "background_jobs_total",
+		Help: "Background job runs, by job and outcome.",
+	},
+	[]string{ "job", "outcome" },
+)
+
+func init() {
+	metrics_registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		http_requests_total,
+		http_request_duration_seconds,
+		logins_total,
+		goals_created_total,
+		goals_completed_total,
+		background_jobs_total,
+	)
+}
+
+//exposes sql.DB.Stats() as go_sql_* metrics. only called once, from main
+func registerDBMetrics(db *sql.DB) {
+	metrics_registry.MustRegister(collectors.NewDBStatsCollector(db, METRICS_NAMESPACE))
+}
+
+func recordBackgroundJob(job string, err error) {
+	outcome := AUDIT_SUCCESS
+
+	if err != nil {
+		outcome = AUDIT_FAILURE
+	}
+
+	background_jobs_total.WithLabelValues(job, outcome).Inc()
+}
+
+func handleMetrics() http.Handler {
+	return promhttp.HandlerFor(metrics_registry, promhttp.HandlerOpts{})
+}
+
+//wraps the response writer to capture the status code
+type statusRecorder struct {
+	http.ResponseWriter
+	status_code int
+}
+
+func (s *statusRecorder) WriteHeader(status_code int) {
+	s.status_code = status_code
+	s.ResponseWriter.WriteHeader(status_code)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+//must wrap the mux directly. the mux sets r.Pattern on the request it
+//is given, which a middleware in between calling r.WithContext would hide.
+//also names the request's trace span after the route
+func metricsMiddleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ ResponseWriter: w, status_code: http.StatusOK }
+
+		mux.ServeHTTP(recorder, r)
+
+		//patterns keep the label set bounded, unlike raw paths
+		route := r.Pattern
+
+		if route == "" {
+			route = "unmatched"
+		}
+
+		requestInfoFromContext(r.Context()).route = route
+
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(route)
+		span.SetAttributes(semconv.HTTPRoute(route))
+
+		http_requests_total.WithLabelValues(r.Method, route, strconv.Itoa(recorder.status_code)).Inc()
+		http_request_duration_seconds.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddlewareUsesRoutePattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /admin/users/{username}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	})
+
+	handler := metricsMiddleware(mux)
+	route := "POST /admin/users/{username}/{action}"
+	before := testutil.ToFloat64(http_requests_total.WithLabelValues(http.MethodPost, route, "422"))
+
+	for _, path := range []string{ "/admin/users/alice/disable", "/admin/users/bob/enable" } {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+	}
+
+	after := testutil.ToFloat64(http_requests_total.WithLabelValues(http.MethodPost, route, "422"))
+
+	if after - before != 2 {
+		t.Errorf("expected both requests counted under the route pattern, got %v", after - before)
+	}
+
+	unmatched_before := testutil.ToFloat64(http_requests_total.WithLabelValues(http.MethodGet, "unmatched", "404"))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nothing/here", nil))
+	unmatched_after := testutil.ToFloat64(http_requests_total.WithLabelValues(http.MethodGet, "unmatched", "404"))
+
+	if unmatched_after - unmatched_before != 1 {
+		t.Error("expected unmatched request to be counted")
+	}
+}
+
+func TestLoginAuditEventsCounted(t *testing.T) {
+	before := testutil.ToFloat64(logins_total.WithLabelValues(AUDIT_FAILURE))
+
+	recordAuditEvent(newMemoryStore(), httptest.NewRequest(http.MethodPost, "/login", nil), AUDIT_LOGIN, "user", AUDIT_FAILURE, "")
+
+	if testutil.ToFloat64(logins_total.WithLabelValues(AUDIT_FAILURE)) - before != 1 {
+		t.Error("failed login was not counted")
+	}
+}
+
+func TestGoalCompletionCounted(t *testing.T) {
+	store := newMemoryStore()
+	user := User{ username: "user", password: "password1" }
+
+	if err := store.InsertUser(context.Background(), &user, ""); err != nil {
+		t.Fatalf("error inserting user: %s", err.Error())
+	}
+
+	goals := []GoalInsert{ { title: "goal", start_date: testDate("2024-01-01"), end_date: testDate("2024-01-31") } }
+
+	if err := store.InsertGoals(context.Background(), "user", &goals); err != nil {
+		t.Fatalf("error inserting goals: %s", err.Error())
+	}
+
+	got, _ := store.GetGoals(context.Background(), "user", testDate("2024-01-01"), testDate("2024-12-31"))
+	handler := handleGoalCompletePost(store)
+	before := testutil.ToFloat64(goals_completed_total)
+
+	//the second attempt finds the goal already complete and isn't counted
+	for _, expected_code := range []int{ http.StatusOK, http.StatusNotFound } {
+		req := httptest.NewRequest(http.MethodPost, "/goals/" + strconv.FormatInt(got[0].id, 10) + "/complete", nil)
+		req.SetPathValue("id", strconv.FormatInt(got[0].id, 10))
+		req = req.WithContext(context.WithValue(req.Context(), "username", "user"))
+
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+
+		if res.Code != expected_code {
+			t.Errorf("expected %d completing goal. got: %d", expected_code, res.Code)
+		}
+	}
+
+	if testutil.ToFloat64(goals_completed_total) - before != 1 {
+		t.Errorf("expected 1 completed goal counted. got: %v", testutil.ToFloat64(goals_completed_total) - before)
+	}
+}
+
+func TestMetricsExposition(t *testing.T) {
+	recordBackgroundJob("config_reload", nil)
+
+	res := httptest.NewRecorder()
+	handleMetrics().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body, _ := io.ReadAll(res.Body)
+
+	for _, name := range []string{ "goal_http_requests_total", "goal_background_jobs_total", "go_goroutines" } {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("expected %s in metrics output", name)
+		}
+	}
+}
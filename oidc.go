@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const OIDC_FLOW_COOKIE_NAME = "oidc_flow"
+const OIDC_FLOW_MAX_AGE = 60 * 10
+const OIDC_DEFAULT_USERNAME_CLAIM = "preferred_username"
+const OIDC_DISCOVERY_TIMEOUT = 10 * time.Second
+
+//users created through oidc have no password. this can never be
+//produced by hashPassword so password login will always fail for them
+const NO_PASSWORD_PARAMS = "!"
+
+type OidcProviderConfig struct {
+	//used in the login and callback urls, /login/oidc/{name}
+	Name           string   `json:"name"`
+	Display_name   string   `json:"display_name"`
+	Issuer         string   `json:"issuer"`
+	Client_id      string   `json:"client_id"`
+	Client_secret  string   `json:"client_secret"`
+	Redirect_url   string   `json:"redirect_url"`
+	Scopes         []string `json:"scopes"`
+	Username_claim string   `json:"username_claim"`
+	//create a User_ on first login when no account has the claimed username,
+	//if the registration mode allows it
+	Auto_provision bool     `json:"auto_provision"`
+	//link the identity to an existing User_ with the claimed username.
+	//only enable for providers that control usernames, otherwise anyone
+	//able to pick a username at the provider can take over an account
+	Link_existing  bool     `json:"link_existing"`
+}
+
+type OidcProvider struct {
+	conf OidcProviderConfig
+
+	//discovery is done lazily so an unreachable provider doesn't stop startup
+	mutex sync.Mutex
+	oauth2_conf *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+type OidcProviderLink struct {
+	Name string
+	DisplayName string
+}
+
+//state kept in a cookie between redirecting to the provider and the callback
+type oidcFlow struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+type OidcIdentity struct {
+	issuer string
+	subject string
+	username string
+	//only set when the provider hasn't marked it unverified
+	email string
+}
+
+func initialiseOidcProviders(confs []OidcProviderConfig) map[string]*OidcProvider {
+	providers := make(map[string]*OidcProvider, len(confs))
+
+	for _, conf := range confs {
+		if conf.Username_claim == "" {
+			conf.Username_claim = OIDC_DEFAULT_USERNAME_CLAIM
+		}
+
+		if conf.Display_name == "" {
+			conf.Display_name = conf.Name
+		}
+
+		providers[conf.Name] = &OidcProvider{ conf: conf }
+
+		slog.Info(
+			"oidc provider configured",
+			"name", conf.Name,
+			"issuer", conf.Issuer,
+		)
+	}
+
+	return providers
+}
+
+func oidcProviderLinks(providers map[string]*OidcProvider, confs []OidcProviderConfig) []OidcProviderLink {
+	links := make([]OidcProviderLink, 0, len(providers))
+
+	//iterate over config rather than the map to keep a stable order
+	for _, conf := range confs {
+		if provider, ok := providers[conf.Name]; ok {
+			links = append(links, OidcProviderLink{
+				Name: provider.conf.Name,
+				DisplayName: provider.conf.Display_name,
+			})
+		}
+	}
+
+	return links
+}
+
+func (p *OidcProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.oauth2_conf != nil {
+		return p.oauth2_conf, p.verifier, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, OIDC_DISCOVERY_TIMEOUT)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, p.conf.Issuer)
+
+	if err != nil {
+		slog.Error(
+			"error discovering oidc provider",
+			"name", p.conf.Name,
+			"issuer", p.conf.Issuer,
+			"err", err.Error(),
+		)
+
+		return nil, nil, err
+	}
+
+	scopes := []string{ oidc.ScopeOpenID }
+
+	for _, scope := range p.conf.Scopes {
+		if scope != oidc.ScopeOpenID {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	p.oauth2_conf = &oauth2.Config{
+		ClientID: p.conf.Client_id,
+		ClientSecret: p.conf.Client_secret,
+		RedirectURL: p.conf.Redirect_url,
+		Endpoint: provider.Endpoint(),
+		Scopes: scopes,
+	}
+
+	p.verifier = provider.Verifier(&oidc.Config{ ClientID: p.conf.Client_id })
+
+	slog.Info("oidc provider discovered", "name", p.conf.Name)
+
+	return p.oauth2_conf, p.verifier, nil
+}
+
+func newOidcFlow(provider_name string) (*oidcFlow, error) {
+	state, err := generateSessionId(32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := generateSessionId(32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	flow := oidcFlow{
+		Provider: provider_name,
+		State: state,
+		Nonce: nonce,
+		Verifier: oauth2.GenerateVerifier(),
+	}
+
+	return &flow, nil
+}
+
+func encodeOidcFlow(flow oidcFlow) (string, error) {
+	json_bytes, err := json.Marshal(flow)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(json_bytes), nil
+}
+
+func decodeOidcFlow(value string) (*oidcFlow, error) {
+	json_bytes, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var flow oidcFlow
+	err = json.Unmarshal(json_bytes, &flow)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &flow, nil
+}
+
+func newOidcFlowCookie(value string, max_age int) *http.Cookie {
+	return &http.Cookie{
+		Name: OIDC_FLOW_COOKIE_NAME,
+		Value: value,
+		Path: "/login/oidc",
+		MaxAge: max_age,
+		HttpOnly: true,
+		Secure: secureCookies(),
+		//must be lax, the callback is a top level navigation from the provider
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func handleOidcLogin(providers map[string]*OidcProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := providers[r.PathValue("provider")]
+
+		if !ok {
+			http.Error(w, "unknown login provider", http.StatusNotFound)
+			return
+		}
+
+		oauth2_conf, _, err := provider.discover(r.Context())
+
+		if err != nil {
+			http.Error(w, "login provider unavailable", http.StatusBadGateway)
+			return
+		}
+
+		flow, err := newOidcFlow(provider.conf.Name)
+		cookie_value := ""
+
+		if err == nil {
+			cookie_value, err = encodeOidcFlow(*flow)
+		}
+
+		if err != nil {
+			requestLogger(r).Error(
+				"error starting oidc login",
+				"provider", provider.conf.Name,
+				"err", err.Error(),
+				"response_code", http.StatusInternalServerError,
+			)
+
+			http.Error(w, "unknown error", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, newOidcFlowCookie(cookie_value, OIDC_FLOW_MAX_AGE))
+
+		auth_url := oauth2_conf.AuthCodeURL(
+			flow.State,
+			oidc.Nonce(flow.Nonce),
+			oauth2.S256ChallengeOption(flow.Verifier),
+		)
+
+		http.Redirect(w, r, auth_url, http.StatusFound)
+	}
+}
+
+//validates the callback against the flow cookie, exchanges the code and
+//verifies the id token. returns the identity claimed by the provider
+func exchangeOidcCallback(provider *OidcProvider, r *http.Request) (*OidcIdentity, int, error) {
+	query := r.URL.Query()
+
+	if provider_err := query.Get("error"); provider_err != "" {
+		return nil, http.StatusUnauthorized, errors.New("provider returned error: " + provider_err)
+	}
+
+	cookie, err := r.Cookie(OIDC_FLOW_COOKIE_NAME)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("missing oidc flow cookie")
+	}
+
+	flow, err := decodeOidcFlow(cookie.Value)
+
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("malformed oidc flow cookie")
+	}
+
+	if flow.Provider != provider.conf.Name ||
+	subtle.ConstantTimeCompare([]byte(flow.State), []byte(query.Get("state"))) != 1 {
+		return nil, http.StatusBadRequest, errors.New("oidc state mismatch")
+	}
+
+	code := query.Get("code")
+
+	if code == "" {
+		return nil, http.StatusBadRequest, errors.New("missing code")
+	}
+
+	oauth2_conf, verifier, err := provider.discover(r.Context())
+
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+
+	token, err := oauth2_conf.Exchange(r.Context(), code, oauth2.VerifierOption(flow.Verifier))
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	raw_id_token, ok := token.Extra("id_token").(string)
+
+	if !ok || raw_id_token == "" {
+		return nil, http.StatusUnauthorized, errors.New("token response has no id_token")
+	}
+
+	id_token, err := verifier.Verify(r.Context(), raw_id_token)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(id_token.Nonce), []byte(flow.Nonce)) != 1 {
+		return nil, http.StatusUnauthorized, errors.New("id token nonce mismatch")
+	}
+
+	claims := map[string]any{}
+	err = id_token.Claims(&claims)
+
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	username, _ := claims[provider.conf.Username_claim].(string)
+
+	if username == "" {
+		return nil, http.StatusUnauthorized, errors.New("id token missing claim " + provider.conf.Username_claim)
+	}
+
+	if utf8.RuneCountInString(username) > USERNAME_MAX_LEN {
+		return nil, http.StatusUnprocessableEntity, errors.New("username claim is too long")
+	}
+
+	if isReservedUsername(username) {
+		return nil, http.StatusUnprocessableEntity, errors.New("username claim is reserved")
+	}
+
+	identity := OidcIdentity{
+		issuer: id_token.Issuer,
+		subject: id_token.Subject,
+		username: username,
+	}
+
+	//not every provider sends email_verified, but one that says false
+	//can't be trusted for the domain allowlist
+	if email_verified, ok := claims["email_verified"].(bool); !ok || email_verified {
+		identity.email, _ = claims["email"].(string)
+	}
+
+	return &identity, 0, nil
+}
+
+//finds the User_ linked to an identity, linking or provisioning one
+//depending on provider config. returns the local username
+func resolveOidcUser(ctx context.Context, store Store, provider *OidcProvider, identity *OidcIdentity) (string, int, error) {
+	username, err := store.GetUserIdentity(ctx, identity.issuer, identity.subject)
+
+	if err == nil {
+		return username, 0, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return "", http.StatusInternalServerError, err
+	}
+
+	_, err = store.GetUser(ctx, identity.username)
+
+	if err == nil {
+		if !provider.conf.Link_existing {
+			return "", http.StatusConflict, errors.New("an account with this username already exists")
+		}
+
+		err = store.InsertUserIdentity(ctx, identity)
+
+		if err != nil {
+			return "", http.StatusInternalServerError, err
+		}
+
+		slog.Info(
+			"linked oidc identity to existing user",
+			"provider", provider.conf.Name,
+			"username", identity.username,
+		)
+
+		return identity.username, 0, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return "", http.StatusInternalServerError, err
+	}
+
+	if !provider.conf.Auto_provision {
+		return "", http.StatusForbidden, errors.New("no account is linked to this login")
+	}
+
+	err_msg, status_code := checkOidcProvisioning(liveConfig().Registration, identity)
+
+	if status_code != 0 {
+		return "", status_code, errors.New(err_msg)
+	}
+
+	err = store.InsertOidcUser(ctx, identity)
+
+	if err != nil {
+		return "", http.StatusInternalServerError, err
+	}
+
+	slog.Info(
+		"provisioned user from oidc identity",
+		"provider", provider.conf.Name,
+		"username", identity.username,
+	)
+
+	return identity.username, 0, nil
+}
+
+func handleOidcCallback(store Store, providers map[string]*OidcProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, ok := providers[r.PathValue("provider")]
+
+		if !ok {
+			http.Error(w, "unknown login provider", http.StatusNotFound)
+			return
+		}
+
+		//the flow is single use whatever the outcome
+		http.SetCookie(w, newOidcFlowCookie("", -1))
+
+		identity, status_code, err := exchangeOidcCallback(provider, r)
+
+		if err != nil {
+			requestLogger(r).Info(
+				"oidc callback rejected",
+				"provider", provider.conf.Name,
+				"err", err.Error(),
+				"response_code", status_code,
+			)
+
+			recordAuditEvent(store, r, AUDIT_LOGIN, "", AUDIT_FAILURE, "oidc:" + provider.conf.Name + " " + err.Error())
+			http.Error(w, "Login failed", status_code)
+			return
+		}
+
+		username, status_code, err := resolveOidcUser(r.Context(), store, provider, identity)
+
+		if err != nil {
+			requestLogger(r).Info(
+				"could not resolve oidc user",
+				"provider", provider.conf.Name,
+				"username", identity.username,
+				"err", err.Error(),
+				"response_code", status_code,
+			)
+
+			recordAuditEvent(store, r, AUDIT_LOGIN, identity.username, AUDIT_FAILURE, "oidc:" + provider.conf.Name + " " + err.Error())
+
+			if status_code == http.StatusInternalServerError {
+				http.Error(w, "Error validating user", status_code)
+			} else {
+				http.Error(w, err.Error(), status_code)
+			}
+
+			return
+		}
+
+		db_user, err := store.GetUser(r.Context(), username)
+
+		if err != nil {
+			http.Error(w, "Error validating user", http.StatusInternalServerError)
+			return
+		}
+
+		if db_user.disabled {
+			requestLogger(r).Info(
+				"oidc login attempted for disabled user",
+				"username", username,
+				"response_code", http.StatusForbidden,
+			)
+
+			recordAuditEvent(store, r, AUDIT_LOGIN, username, AUDIT_FAILURE, "oidc:" + provider.conf.Name + " account is disabled")
+			http.Error(w, "Account is disabled", http.StatusForbidden)
+			return
+		}
+
+		session_id, err := CreateUserSessionId(r.Context(), store, username)
+
+		if err != nil {
+			requestLogger(r).Error(
+				"error generating session id",
+				"username", username,
+				"response_code", http.StatusInternalServerError,
+			)
+
+			http.Error(w, "Error validating user", http.StatusInternalServerError)
+			return
+		}
+
+		requestLogger(r).Info(
+			"successfully logged in user with oidc",
+			"provider", provider.conf.Name,
+			"username", username,
+			"response_code", http.StatusSeeOther,
+		)
+
+		recordAuditEvent(store, r, AUDIT_LOGIN, username, AUDIT_SUCCESS, "oidc:" + provider.conf.Name)
+		http.SetCookie(w, newSessionCookie(session_id))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+//minimal oidc provider supporting discovery, jwks and the token
+//endpoint with pkce. the authorize step is skipped, tests call
+//authorise directly with the params from the login redirect
+type mockOidcProvider struct {
+	server *httptest.Server
+	key *rsa.PrivateKey
+	claims map[string]any
+
+	mutex sync.Mutex
+	//code -> authorize params
+	codes map[string]url.Values
+}
+
+func newMockOidcProvider(t *testing.T) *mockOidcProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatalf("error generating rsa key: %s", err.Error())
+	}
+
+	mock := &mockOidcProvider{
+		key: key,
+		codes: map[string]url.Values{},
+		claims: map[string]any{ "sub": "subject-1", "preferred_username": "oidc_user" },
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer": mock.server.URL,
+			"authorization_endpoint": mock.server.URL + "/authorize",
+			"token_endpoint": mock.server.URL + "/token",
+			"jwks_uri": mock.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{ "RS256" },
+		})
+	})
+
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{
+				{ Key: &mock.key.PublicKey, KeyID: "1", Algorithm: "RS256", Use: "sig" },
+			},
+		})
+	})
+
+	mux.HandleFunc("POST /token", mock.handleToken(t))
+
+	mock.server = httptest.NewServer(mux)
+	t.Cleanup(mock.server.Close)
+
+	return mock
+}
+
+//stands in for the user approving the login at the provider. returns a code
+func (m *mockOidcProvider) authorise(params url.Values) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	code, _ := generateSessionId(16)
+	m.codes[code] = params
+
+	return code
+}
+
+func (m *mockOidcProvider) handleToken(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		m.mutex.Lock()
+		params, ok := m.codes[r.PostForm.Get("code")]
+		delete(m.codes, r.PostForm.Get("code"))
+		m.mutex.Unlock()
+
+		if !ok {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		verifier_hash := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		challenge := base64.RawURLEncoding.EncodeToString(verifier_hash[:])
+
+		if params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") != challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		claims := map[string]any{
+			"iss": m.server.URL,
+			"aud": params.Get("client_id"),
+			"exp": time.Now().Add(time.Minute).Unix(),
+			"iat": time.Now().Unix(),
+			"nonce": params.Get("nonce"),
+		}
+
+		for key, value := range m.claims {
+			claims[key] = value
+		}
+
+		signer, err := jose.NewSigner(
+			jose.SigningKey{ Algorithm: jose.RS256, Key: m.key },
+			(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "1"),
+		)
+
+		if err != nil {
+			t.Errorf("error creating signer: %s", err.Error())
+			return
+		}
+
+		payload, _ := json.Marshal(claims)
+		jws, err := signer.Sign(payload)
+
+		if err != nil {
+			t.Errorf("error signing id token: %s", err.Error())
+			return
+		}
+
+		id_token, _ := jws.CompactSerialize()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type": "Bearer",
+			"expires_in": 60,
+			"id_token": id_token,
+		})
+	}
+}
+
+func newTestOidcProvider(mock *mockOidcProvider) map[string]*OidcProvider {
+	return initialiseOidcProviders([]OidcProviderConfig{
+		{
+			Name: "mock",
+			Issuer: mock.server.URL,
+			Client_id: "client",
+			Client_secret: "secret",
+			Redirect_url: "http://localhost/login/oidc/mock/callback",
+		},
+	})
+}
+
+//runs the login redirect and returns the authorize params and the flow cookie
+func startOidcLogin(t *testing.T, providers map[string]*OidcProvider) (url.Values, *http.Cookie) {
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc/mock", nil)
+	req.SetPathValue("provider", "mock")
+	rec := httptest.NewRecorder()
+
+	handleOidcLogin(providers).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect to provider. got: %d %s", rec.Code, rec.Body.String())
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+
+	if err != nil {
+		t.Fatalf("malformed redirect location: %s", err.Error())
+	}
+
+	cookies := rec.Result().Cookies()
+
+	if len(cookies) != 1 || cookies[0].Name != OIDC_FLOW_COOKIE_NAME {
+		t.Fatalf("expected oidc flow cookie. got: %v", cookies)
+	}
+
+	return location.Query(), cookies[0]
+}
+
+func oidcCallbackRequest(state string, code string, cookie *http.Cookie) *http.Request {
+	query := url.Values{ "state": { state }, "code": { code } }
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc/mock/callback?" + query.Encode(), nil)
+	req.SetPathValue("provider", "mock")
+
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	return req
+}
+
+func TestOidcLoginRedirect(t *testing.T) {
+	mock := newMockOidcProvider(t)
+	providers := newTestOidcProvider(mock)
+
+	params, _ := startOidcLogin(t, providers)
+
+	if params.Get("code_challenge_method") != "S256" || params.Get("code_challenge") == "" {
+		t.Error("expected pkce S256 challenge in authorize url")
+	}
+
+	if params.Get("state") == "" || params.Get("nonce") == "" {
+		t.Error("expected state and nonce in authorize url")
+	}
+
+	if params.Get("client_id") != "client" || params.Get("scope") != "openid" {
+		t.Errorf("unexpected client_id or scope. got: %s %s", params.Get("client_id"), params.Get("scope"))
+	}
+}
+
+func TestOidcExchange(t *testing.T) {
+	mock := newMockOidcProvider(t)
+	providers := newTestOidcProvider(mock)
+
+	params, cookie := startOidcLogin(t, providers)
+	code := mock.authorise(params)
+
+	identity, status_code, err := exchangeOidcCallback(providers["mock"], oidcCallbackRequest(params.Get("state"), code, cookie))
+
+	if err != nil {
+		t.Fatalf("error exchanging code. status: %d, err: %s", status_code, err.Error())
+	}
+
+	if identity.issuer != mock.server.URL || identity.subject != "subject-1" || identity.username != "oidc_user" {
+		t.Errorf("unexpected identity. got: %+v", *identity)
+	}
+}
+
+func TestOidcExchangeRejectsBadState(t *testing.T) {
+	mock := newMockOidcProvider(t)
+	providers := newTestOidcProvider(mock)
+
+	params, cookie := startOidcLogin(t, providers)
+	code := mock.authorise(params)
+
+	_, status_code, err := exchangeOidcCallback(providers["mock"], oidcCallbackRequest("wrong", code, cookie))
+
+	if err == nil || status_code != http.StatusBadRequest {
+		t.Errorf("expected state mismatch to be rejected. got status: %d", status_code)
+	}
+
+	_, status_code, err = exchangeOidcCallback(providers["mock"], oidcCallbackRequest(params.Get("state"), code, nil))
+
+	if err == nil || status_code != http.StatusBadRequest {
+		t.Errorf("expected missing flow cookie to be rejected. got status: %d", status_code)
+	}
+}
+
+func TestOidcExchangeRejectsBadNonce(t *testing.T) {
+	mock := newMockOidcProvider(t)
+	providers := newTestOidcProvider(mock)
+
+	params, cookie := startOidcLogin(t, providers)
+	params.Set("nonce", "replayed")
+	code := mock.authorise(params)
+
+	_, status_code, err := exchangeOidcCallback(providers["mock"], oidcCallbackRequest(params.Get("state"), code, cookie))
+
+	if err == nil || status_code != http.StatusUnauthorized {
+		t.Errorf("expected nonce mismatch to be rejected. got status: %d", status_code)
+	}
+}
+
+func TestOidcExchangeRejectsMissingClaim(t *testing.T) {
+	mock := newMockOidcProvider(t)
+	delete(mock.claims, "preferred_username")
+	providers := newTestOidcProvider(mock)
+
+	params, cookie := startOidcLogin(t, providers)
+	code := mock.authorise(params)
+
+	_, status_code, err := exchangeOidcCallback(providers["mock"], oidcCallbackRequest(params.Get("state"), code, cookie))
+
+	if err == nil || status_code != http.StatusUnauthorized {
+		t.Errorf("expected missing username claim to be rejected. got status: %d", status_code)
+	}
+}
+
+func TestOidcExchangeRejectsReservedUsername(t *testing.T) {
+	mock := newMockOidcProvider(t)
+	mock.claims["preferred_username"] = DELETED_USERNAME
+	providers := newTestOidcProvider(mock)
+
+	params, cookie := startOidcLogin(t, providers)
+	code := mock.authorise(params)
+
+	_, status_code, err := exchangeOidcCallback(providers["mock"], oidcCallbackRequest(params.Get("state"), code, cookie))
+
+	if err == nil || status_code != http.StatusUnprocessableEntity {
+		t.Errorf("expected the reserved username to be rejected. got status: %d", status_code)
+	}
+}
+
+func TestOidcExchangeIgnoresUnverifiedEmail(t *testing.T) {
+	mock := newMockOidcProvider(t)
+	mock.claims["email"] = "user@example.com"
+	mock.claims["email_verified"] = false
+	providers := newTestOidcProvider(mock)
+
+	params, cookie := startOidcLogin(t, providers)
+	code := mock.authorise(params)
+
+	identity, _, err := exchangeOidcCallback(providers["mock"], oidcCallbackRequest(params.Get("state"), code, cookie))
+
+	if err != nil || identity.email != "" {
+		t.Errorf("expected an unverified email to be dropped. got: %+v %v", identity, err)
+	}
+}
+
+//auto provisioning is a way of registering, so follows the same mode
+func TestResolveOidcUserRegistrationModes(t *testing.T) {
+	provider := &OidcProvider{ conf: OidcProviderConfig{ Name: "mock", Auto_provision: true } }
+	domain := RegistrationConfig{ Mode: REGISTRATION_DOMAIN, Allowed_domains: []string{ "example.com" } }
+
+	cases := []struct {
+		name string
+		registration RegistrationConfig
+		email string
+		status_code int
+	}{
+		{ "open", RegistrationConfig{ Mode: REGISTRATION_OPEN }, "", 0 },
+		{ "default", RegistrationConfig{}, "", 0 },
+		{ "closed", RegistrationConfig{ Mode: REGISTRATION_CLOSED }, "user@example.com", http.StatusForbidden },
+		{ "invite", RegistrationConfig{ Mode: REGISTRATION_INVITE }, "user@example.com", http.StatusForbidden },
+		{ "domain allowed", domain, "user@Example.com", 0 },
+		{ "domain not allowed", domain, "user@example.org", http.StatusForbidden },
+		{ "domain without email", domain, "", http.StatusForbidden },
+	}
+
+	for _, c := range cases {
+		conf := Config{ Registration: c.registration }
+		live_config.Store(&conf)
+
+		store := newMemoryStore()
+		identity := OidcIdentity{ issuer: "https://idp", subject: "subject-1", username: "oidc_user", email: c.email }
+
+		username, status_code, err := resolveOidcUser(context.Background(), store, provider, &identity)
+
+		if status_code != c.status_code {
+			t.Errorf("%s: expected status %d. got: %d %v", c.name, c.status_code, status_code, err)
+			continue
+		}
+
+		_, get_err := store.GetUser(context.Background(), "oidc_user")
+
+		if c.status_code == 0 && (username != "oidc_user" || get_err != nil) {
+			t.Errorf("%s: expected the user to be provisioned. got: %s %v", c.name, username, get_err)
+		}
+
+		if c.status_code != 0 && !errors.Is(get_err, sql.ErrNoRows) {
+			t.Errorf("%s: expected no user to be provisioned. got: %v", c.name, get_err)
+		}
+	}
+
+	live_config.Store(nil)
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"log/slog"
+	"os"
 	"strings"
 
 	"golang.org/x/crypto/argon2"
@@ -18,6 +22,7 @@ type argon2_params struct {
 	threads  uint8
 	key_len  uint32
 	salt_len uint16
+	peppered bool
 }
 
 var default_params = argon2_params{
@@ -28,20 +33,112 @@ var default_params = argon2_params{
 	salt_len: 16,
 }
 
+//params used for hashing new passwords. starts as default_params
+//and is overridden from config by applyPasswordConfig
+var current_params = default_params
+
+//server side secret mixed into every password before hashing.
+//nil when no pepper file is configured
+var password_pepper []byte
+
+func applyPasswordConfig(conf *Config) error {
+	params := default_params
+
+	if conf.Argon2.Time != 0 {
+		params.time = conf.Argon2.Time
+	}
+	if conf.Argon2.Memory != 0 {
+		params.memory = conf.Argon2.Memory
+	}
+	if conf.Argon2.Threads != 0 {
+		params.threads = conf.Argon2.Threads
+	}
+	if conf.Argon2.Key_len != 0 {
+		params.key_len = conf.Argon2.Key_len
+	}
+	if conf.Argon2.Salt_len != 0 {
+		params.salt_len = conf.Argon2.Salt_len
+	}
+
+	var pepper []byte = nil
+
+	if conf.Argon2.Pepper_file != "" {
+		content, err := os.ReadFile(conf.Argon2.Pepper_file)
+
+		if err != nil {
+			slog.Error("error reading pepper file: " + err.Error())
+			return err
+		}
+
+		pepper = []byte(strings.TrimSpace(string(content)))
+
+		if len(pepper) == 0 {
+			err = errors.New("pepper file is empty")
+			slog.Error(err.Error())
+			return err
+		}
+
+		params.peppered = true
+	}
+
+	current_params = params
+	password_pepper = pepper
+
+	slog.Info(
+		"argon2 params set",
+		"memory", params.memory,
+		"time", params.time,
+		"threads", params.threads,
+		"key_len", params.key_len,
+		"salt_len", params.salt_len,
+		"peppered", params.peppered,
+	)
+
+	return nil
+}
+
+//when peppered, the password is run through a HMAC keyed with the pepper
+//so that a leaked db alone is not enough to brute force passwords
+func pepperPassword(password string, peppered bool) ([]byte, error) {
+	if !peppered {
+		return []byte(password), nil
+	}
+
+	if password_pepper == nil {
+		return nil, errors.New("password hash is peppered but no pepper is configured")
+	}
+
+	mac := hmac.New(sha256.New, password_pepper)
+	mac.Write([]byte(password))
+
+	return mac.Sum(nil), nil
+}
+
 //Hashes a password with argon2 and returns a string
 //containing argon2 params for hashing the password
 func hashPassword(password string) string {
-	salt := generateSalt(default_params.salt_len)
-	hash := argon2.IDKey([]byte(password), salt, default_params.time, default_params.memory, default_params.threads, default_params.key_len)
+	params := current_params
+	salt := generateSalt(params.salt_len)
+
+	//cannot fail as current_params is only peppered when a pepper is loaded
+	input, _ := pepperPassword(password, params.peppered)
+	hash := argon2.IDKey(input, salt, params.time, params.memory, params.threads, params.key_len)
 
 	b64_salt := base64.RawStdEncoding.EncodeToString(salt)
 	b64_hash := base64.RawStdEncoding.EncodeToString(hash)
 
-	format_str := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+	pepper_str := ""
+
+	if params.peppered {
+		pepper_str = ",pepper=1"
+	}
+
+	format_str := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d%s$%s$%s",
 		argon2.Version,
-		default_params.memory,
-		default_params.time,
-		default_params.threads,
+		params.memory,
+		params.time,
+		params.threads,
+		pepper_str,
 		b64_salt,
 		b64_hash,
 	)
@@ -49,6 +146,28 @@ func hashPassword(password string) string {
 	return format_str
 }
 
+//reports whether a stored hash was made with params weaker than
+//current_params and should be rehashed on the next successful login
+func needsRehash(password_params string) (bool, error) {
+	params, _, _, err := extractPasswordParams(password_params)
+
+	if err != nil {
+		return false, err
+	}
+
+	if params.version != argon2.Version ||
+	params.memory < current_params.memory ||
+	params.time < current_params.time ||
+	params.threads < current_params.threads ||
+	params.key_len < current_params.key_len ||
+	params.salt_len < current_params.salt_len ||
+	params.peppered != current_params.peppered {
+		return true, nil
+	}
+
+	return false, nil
+}
+
 func generateSalt(length uint16) []byte {
 	salt := make([]byte, length)
 	rand.Read(salt)
@@ -58,12 +177,19 @@ func generateSalt(length uint16) []byte {
 
 func comparePasswordWithHash(password string, password_params string) (bool, error) {
 	params, salt, existing_hash, err := extractPasswordParams(password_params)
-	in_hash := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, params.key_len)
 
 	if err != nil {
 		return false, err
 	}
 
+	input, err := pepperPassword(password, params.peppered)
+
+	if err != nil {
+		return false, err
+	}
+
+	in_hash := argon2.IDKey(input, salt, params.time, params.memory, params.threads, params.key_len)
+
 	if subtle.ConstantTimeCompare(in_hash, existing_hash) == 1 {
 		return true, nil
 	}
@@ -110,6 +236,8 @@ func extractPasswordParams(password_params string) (
 		return nil, nil, nil, errors.New("invalid param string")
 	}
 
+	params.peppered = strings.HasSuffix(split_params[3], ",pepper=1")
+
 	salt, err = base64.RawStdEncoding.DecodeString(split_params[4])
 
 	if err != nil {
@@ -0,0 +1,111 @@
+package main
+
+import "testing"
+
+func TestComparePasswordWithHash(t *testing.T) {
+	hash := hashPassword("password")
+
+	match, err := comparePasswordWithHash("password", hash)
+
+	if err != nil {
+		t.Errorf("error comparing password, err: %s", err.Error())
+	} else if !match {
+		t.Error("expected password to match its hash")
+	}
+
+	match, err = comparePasswordWithHash("wrong password", hash)
+
+	if err != nil {
+		t.Errorf("error comparing password, err: %s", err.Error())
+	} else if match {
+		t.Error("expected wrong password not to match")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	defer func() { current_params = default_params }()
+
+	old_hash := hashPassword("password")
+
+	rehash, err := needsRehash(old_hash)
+
+	if err != nil {
+		t.Errorf("error checking rehash, err: %s", err.Error())
+	} else if rehash {
+		t.Error("hash made with current params should not need rehash")
+	}
+
+	current_params.memory *= 2
+
+	rehash, err = needsRehash(old_hash)
+
+	if err != nil {
+		t.Errorf("error checking rehash, err: %s", err.Error())
+	} else if !rehash {
+		t.Error("hash made with less memory should need rehash")
+	}
+
+	current_params = default_params
+	current_params.key_len = 32
+
+	rehash, err = needsRehash(old_hash)
+
+	if err != nil {
+		t.Errorf("error checking rehash, err: %s", err.Error())
+	} else if !rehash {
+		t.Error("hash made with shorter key should need rehash")
+	}
+}
+
+func TestPepperedHash(t *testing.T) {
+	defer func() {
+		current_params = default_params
+		password_pepper = nil
+	}()
+
+	unpeppered_hash := hashPassword("password")
+
+	password_pepper = []byte("pepper")
+	current_params.peppered = true
+
+	peppered_hash := hashPassword("password")
+
+	match, err := comparePasswordWithHash("password", peppered_hash)
+
+	if err != nil {
+		t.Errorf("error comparing peppered password, err: %s", err.Error())
+	} else if !match {
+		t.Error("expected password to match its peppered hash")
+	}
+
+	//old hashes from before the pepper was added must still validate
+	match, err = comparePasswordWithHash("password", unpeppered_hash)
+
+	if err != nil {
+		t.Errorf("error comparing unpeppered password, err: %s", err.Error())
+	} else if !match {
+		t.Error("expected password to match its unpeppered hash")
+	}
+
+	rehash, _ := needsRehash(unpeppered_hash)
+
+	if !rehash {
+		t.Error("unpeppered hash should need rehash once a pepper is configured")
+	}
+
+	password_pepper = []byte("another pepper")
+
+	match, _ = comparePasswordWithHash("password", peppered_hash)
+
+	if match {
+		t.Error("expected password not to match with a different pepper")
+	}
+
+	password_pepper = nil
+
+	_, err = comparePasswordWithHash("password", peppered_hash)
+
+	if err == nil {
+		t.Error("expected error comparing peppered hash without a pepper")
+	}
+}
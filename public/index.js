@@ -120,13 +120,6 @@ function init(){
 
 init();
 
-/**
- * @param {String} name
- */
-function deleteCookie(name){
-  document.cookie = name + "=; Path=/; Expires=Thu, 01 Jan 1970 00:00:01 GMT;"
-}
-
 /**
  * @param {Date} date
  */
@@ -154,13 +147,34 @@ function addDaysToDate(date, days){
   return newDate;
 }
 
+function getCsrfToken(){
+  return document.querySelector("meta[name='csrf-token']").content;
+}
+
 async function logout(){
-  await fetch("/logout", { method: "POST" });
+  await fetch("/logout", {
+    method: "POST",
+    headers: { "X-CSRF-Token": getCsrfToken() },
+  });
 
-  deleteCookie("session_id");
+  //session cookie is HttpOnly so the server clears it in the logout response
   window.location.replace("/login");
 }
 
+/**
+ * @param {number} id
+ */
+async function completeGoal(id){
+  const res = await fetch("/goals/" + id + "/complete", {
+    method: "POST",
+    headers: { "X-CSRF-Token": getCsrfToken() },
+  });
+
+  if(res.ok){
+    refreshDisplayTable();
+  }
+}
+
 function resetGoalInputTable(){
   for(let i = 0; i < goalInputTableBody.children.length; i++){
     const child = goalInputTableBody.children[i];
@@ -169,6 +183,8 @@ function resetGoalInputTable(){
       child.innerHTML = `
         <td><input type="text" name="title" required/></td>
         <td><textarea rows="1" name="notes"/></textarea></td>
+    <td><input type="text" name="tags" placeholder="comma separated"/></td>
+        <td><input type="text" name="tags" placeholder="comma separated"/></td>
         <td><input type="date" name="due" required/></td>
         <td style='text-align: center;'>
           <button class="minus-button" disabled onclick="removeRowFromGoalTable(this)" type="button">&minus;</button>
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+const REDACTED = "[REDACTED]"
+
+//attribute keys containing any of these, case insensitively, have their
+//values masked by redactingHandler
+var sensitive_log_keys = []string{
+	"password",
+	"secret",
+	"token",
+	"session_id",
+	"pepper",
+	"invite_code",
+	"authorization",
+	"cookie",
+	"dsn",
+}
+
+func isSensitiveLogKey(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, sensitive := range sensitive_log_keys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//masks the values of sensitive attributes before they reach the wrapped
+//handler. a last line of defence, secrets still shouldn't be logged in
+//the first place
+type redactingHandler struct {
+	next slog.Handler
+}
+
+func newRedactingHandler(next slog.Handler) *redactingHandler {
+	return &redactingHandler{ next: next }
+}
+
+func redactAttr(attr slog.Attr) slog.Attr {
+	if isSensitiveLogKey(attr.Key) {
+		return slog.String(attr.Key, REDACTED)
+	}
+
+	//resolves LogValuers such as Config first so their output is checked too
+	value := attr.Value.Resolve()
+
+	if value.Kind() != slog.KindGroup {
+		return slog.Attr{ Key: attr.Key, Value: value }
+	}
+
+	group := value.Group()
+	redacted := make([]slog.Attr, len(group))
+
+	for i, group_attr := range group {
+		redacted[i] = redactAttr(group_attr)
+	}
+
+	return slog.Attr{ Key: attr.Key, Value: slog.GroupValue(redacted...) }
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+
+	for i, attr := range attrs {
+		redacted[i] = redactAttr(attr)
+	}
+
+	return newRedactingHandler(h.next.WithAttrs(redacted))
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return newRedactingHandler(h.next.WithGroup(name))
+}
+
+//Config without its methods, so logging it doesn't call LogValue again
+type redactedConfig Config
+
+//logs the config with secrets masked, so the whole config can be logged safely
+func (c Config) LogValue() slog.Value {
+	redacted := c
+
+	if redacted.Db.Password != "" {
+		redacted.Db.Password = REDACTED
+	}
+
+	//a dsn may hold a password, so it's masked whole
+	if redacted.Db.Dsn != "" {
+		redacted.Db.Dsn = REDACTED
+	}
+
+	redacted.Oidc_providers = make([]OidcProviderConfig, len(c.Oidc_providers))
+
+	for i, provider := range c.Oidc_providers {
+		if provider.Client_secret != "" {
+			provider.Client_secret = REDACTED
+		}
+
+		redacted.Oidc_providers[i] = provider
+	}
+
+	return slog.AnyValue(redactedConfig(redacted))
+}
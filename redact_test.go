@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const TEST_SECRET = "hunter2-do-not-log"
+
+func TestRedactingHandler(t *testing.T) {
+	for _, format := range []string{ LOG_FORMAT_TEXT, LOG_FORMAT_JSON } {
+		buf := bytes.Buffer{}
+		logger := slog.New(newLogHandler(&LogConfig{ Level: "debug", Format: format }, &buf))
+
+		logger.Info("login", "username", "user", "password", TEST_SECRET)
+		logger.Info("session", "session_id", TEST_SECRET, "csrf_token", TEST_SECRET)
+		logger.With("Authorization", "Bearer " + TEST_SECRET).Info("request")
+		logger.Info("oidc", slog.Group("tokens", "id_token", TEST_SECRET, "refresh_token", TEST_SECRET))
+		logger.Info("nested", slog.Group("upstream", slog.Group("db", "password", TEST_SECRET)))
+
+		output := buf.String()
+
+		if strings.Contains(output, TEST_SECRET) {
+			t.Errorf("%s output contains a secret:\n%s", format, output)
+		}
+
+		if !strings.Contains(output, "user") || !strings.Contains(output, REDACTED) {
+			t.Errorf("%s output should keep other attributes and mark redactions:\n%s", format, output)
+		}
+	}
+}
+
+func TestConfigLogValue(t *testing.T) {
+	conf := Config{ Host: "localhost" }
+	conf.Db.Password = TEST_SECRET
+	conf.Db.Dsn = "postgres://goal:" + TEST_SECRET + "@localhost/goal"
+	conf.Oidc_providers = []OidcProviderConfig{ { Name: "corp", Client_secret: TEST_SECRET } }
+
+	text_buf := bytes.Buffer{}
+	json_buf := bytes.Buffer{}
+
+	//plain handlers, LogValue has to hide secrets on its own
+	slog.New(slog.NewTextHandler(&text_buf, nil)).Info("config", "config", conf)
+	slog.New(slog.NewJSONHandler(&json_buf, nil)).Info("config", "config", conf)
+
+	for _, output := range []string{ text_buf.String(), json_buf.String() } {
+		if strings.Contains(output, TEST_SECRET) {
+			t.Errorf("config output contains a secret:\n%s", output)
+		}
+
+		if !strings.Contains(output, "localhost") {
+			t.Errorf("config output should include non secret fields:\n%s", output)
+		}
+	}
+
+	if conf.Db.Password != TEST_SECRET || conf.Oidc_providers[0].Client_secret != TEST_SECRET {
+		t.Error("LogValue modified the config it was called on")
+	}
+}
+
+func TestParseConfigDoesNotLogSecrets(t *testing.T) {
+	buf := captureLogs(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	json := `{ "host": "localhost", "port": 1800, "db": { "host": "localhost", "port": 5432, "database_name": "goal", "username": "goal", "password": "` + TEST_SECRET + `" },
+		"oidc_providers": [ { "name": "corp", "issuer": "https://id.example.com", "client_id": "goal", "client_secret": "` + TEST_SECRET + `", "redirect_url": "https://goal.example.com/cb" } ] }`
+
+	err := os.WriteFile(path, []byte(json), 0600)
+
+	if err != nil {
+		t.Fatalf("error writing config file for test: %s", err.Error())
+	}
+
+	_, err = parseConfig(path)
+
+	if err != nil {
+		t.Fatalf("parseConfig failed: %s", err.Error())
+	}
+
+	if strings.Contains(buf.String(), TEST_SECRET) {
+		t.Errorf("parseConfig logged a secret:\n%s", buf.String())
+	}
+
+	if !strings.Contains(buf.String(), "parsed config") {
+		t.Error("expected the parsed config to be logged at debug level")
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const REGISTRATION_OPEN = "open"
+const REGISTRATION_CLOSED = "closed"
+const REGISTRATION_INVITE = "invite"
+const REGISTRATION_DOMAIN = "domain"
+
+const INVITE_CODE_LEN_BYTE = 16
+
+type RegistrationConfig struct {
+	//one of open, closed, invite or domain. empty is treated as open
+	Mode            string   `json:"mode"`
+	//email domains accepted in domain mode, eg. example.com
+	Allowed_domains []string `json:"allowed_domains"`
+}
+
+func validateRegistrationConfig(conf *RegistrationConfig) error {
+	switch conf.Mode {
+	case "", REGISTRATION_OPEN, REGISTRATION_CLOSED, REGISTRATION_INVITE:
+		return nil
+	case REGISTRATION_DOMAIN:
+		if len(conf.Allowed_domains) == 0 {
+			return schemaViolation{
+				pointer: "/registration/allowed_domains",
+				message: "required when mode is domain",
+			}
+		}
+
+		return nil
+	default:
+		return schemaViolation{
+			pointer: "/registration/mode",
+			message: fmt.Sprintf("%s is not one of open, closed, invite, domain", conf.Mode),
+		}
+	}
+}
+
+func registrationMode(conf RegistrationConfig) string {
+	if conf.Mode == "" {
+		return REGISTRATION_OPEN
+	}
+
+	return conf.Mode
+}
+
+func isAllowedEmailDomain(email string, allowed_domains []string) bool {
+	at := strings.LastIndex(email, "@")
+
+	if at == -1 {
+		return false
+	}
+
+	domain := email[at + 1:]
+
+	for _, allowed := range allowed_domains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//checks the form against the registration mode. returns the invite code
+//to redeem, if any, and sets the user's email in domain mode
+func checkRegistrationForm(conf RegistrationConfig, form url.Values, user *User) (invite_code string, err_msg string, status_code int) {
+	switch registrationMode(conf) {
+	case REGISTRATION_CLOSED:
+		return "", "Registration is disabled", http.StatusForbidden
+	case REGISTRATION_INVITE:
+		invite_code = strings.TrimSpace(form.Get("invite_code"))
+
+		if invite_code == "" {
+			return "", "An invite code is required to register", http.StatusForbidden
+		}
+
+		return invite_code, "", 0
+	case REGISTRATION_DOMAIN:
+		address, err := mail.ParseAddress(form.Get("email"))
+
+		//ParseAddress accepts "Name <email>", only a bare address is wanted
+		if err != nil || address.Address != strings.TrimSpace(form.Get("email")) {
+			return "", "A valid email address is required to register", http.StatusUnprocessableEntity
+		}
+
+		if !isAllowedEmailDomain(address.Address, conf.Allowed_domains) {
+			return "", "Registration is not open to this email domain", http.StatusForbidden
+		}
+
+		user.email = address.Address
+
+		return "", "", 0
+	default:
+		return "", "", 0
+	}
+}
+
+//the registration mode applied to creating a user from an oidc login, so
+//a provider can't be used to get around it. there's no way to give an
+//invite code through a provider, so invite mode refuses like closed
+func checkOidcProvisioning(conf RegistrationConfig, identity *OidcIdentity) (err_msg string, status_code int) {
+	switch registrationMode(conf) {
+	case REGISTRATION_CLOSED:
+		return "Registration is disabled", http.StatusForbidden
+	case REGISTRATION_INVITE:
+		return "An invite code is required to register", http.StatusForbidden
+	case REGISTRATION_DOMAIN:
+		if identity.email == "" {
+			return "A verified email address is required to register", http.StatusForbidden
+		}
+
+		if !isAllowedEmailDomain(identity.email, conf.Allowed_domains) {
+			return "Registration is not open to this email domain", http.StatusForbidden
+		}
+
+		return "", 0
+	default:
+		return "", 0
+	}
+}
+
+func handleRegisterGet(w http.ResponseWriter, r *http.Request) {
+	conf := liveConfig().Registration
+
+	writePageTemplate(w, r, "register.html", PageTemplate{
+		RegistrationMode: registrationMode(conf),
+		AllowedDomains: conf.Allowed_domains,
+	})
+}
+
+func handleAdminInvitePost(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+
+		if err != nil {
+			http.Error(w, "malformed form request", http.StatusBadRequest)
+			return
+		}
+
+		max_uses := 1
+
+		if max_uses_str := r.PostForm.Get("max_uses"); max_uses_str != "" {
+			max_uses, err = strconv.Atoi(max_uses_str)
+
+			if err != nil || max_uses < 1 {
+				http.Error(w, "max_uses must be a positive number", http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		var expires *time.Time = nil
+
+		if expires_days_str := r.PostForm.Get("expires_days"); expires_days_str != "" {
+			expires_days, err := strconv.Atoi(expires_days_str)
+
+			if err != nil || expires_days < 1 {
+				http.Error(w, "expires_days must be a positive number", http.StatusUnprocessableEntity)
+				return
+			}
+
+			expires_time := time.Now().AddDate(0, 0, expires_days)
+			expires = &expires_time
+		}
+
+		note := r.PostForm.Get("note")
+
+		if len(note) > 255 {
+			http.Error(w, "note must be 255 characters or fewer", http.StatusUnprocessableEntity)
+			return
+		}
+
+		code, err := generateSessionId(INVITE_CODE_LEN_BYTE)
+
+		if err != nil {
+			http.Error(w, "error generating invite code", http.StatusInternalServerError)
+			return
+		}
+
+		admin := r.Context().Value("username").(string)
+		err = store.InsertInviteCode(r.Context(), sha256.Sum256([]byte(code)), admin, expires, max_uses, note)
+
+		if err != nil {
+			http.Error(w, "error creating invite code", http.StatusInternalServerError)
+			return
+		}
+
+		requestLogger(r).Info(
+			"admin created invite code",
+			"admin", admin,
+			"max_uses", max_uses,
+			"response_code", http.StatusOK,
+		)
+
+		recordAuditEvent(store, r, AUDIT_INVITE_CREATED, admin, AUDIT_SUCCESS, fmt.Sprintf("max_uses=%d", max_uses))
+
+		//only the hash is stored so this is the one chance to show the code
+		writeAdminPage(w, r, store, code)
+	}
+}
+
+func handleAdminInviteRevoke(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+
+		if err != nil {
+			http.Error(w, "invalid invite id", http.StatusBadRequest)
+			return
+		}
+
+		err = store.RevokeInviteCode(r.Context(), id)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "invite not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "error revoking invite code", http.StatusInternalServerError)
+			return
+		}
+
+		requestLogger(r).Info(
+			"admin revoked invite code",
+			"admin", r.Context().Value("username"),
+			"id", id,
+			"response_code", http.StatusSeeOther,
+		)
+
+		admin := r.Context().Value("username").(string)
+		recordAuditEvent(store, r, AUDIT_INVITE_REVOKED, admin, AUDIT_SUCCESS, fmt.Sprintf("id=%d", id))
+
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCheckRegistrationForm(t *testing.T) {
+	domain_conf := RegistrationConfig{
+		Mode: REGISTRATION_DOMAIN,
+		Allowed_domains: []string{ "example.com" },
+	}
+
+	tests := []struct {
+		name string
+		conf RegistrationConfig
+		form url.Values
+		expected_status int
+		expected_invite string
+		expected_email string
+	}{
+		{ "default is open", RegistrationConfig{}, url.Values{}, 0, "", "" },
+		{ "open", RegistrationConfig{ Mode: REGISTRATION_OPEN }, url.Values{}, 0, "", "" },
+		{ "closed", RegistrationConfig{ Mode: REGISTRATION_CLOSED }, url.Values{}, http.StatusForbidden, "", "" },
+		{
+			"invite without code",
+			RegistrationConfig{ Mode: REGISTRATION_INVITE },
+			url.Values{},
+			http.StatusForbidden, "", "",
+		},
+		{
+			"invite with code",
+			RegistrationConfig{ Mode: REGISTRATION_INVITE },
+			url.Values{ "invite_code": { " abc " } },
+			0, "abc", "",
+		},
+		{
+			"domain allowed",
+			domain_conf,
+			url.Values{ "email": { "user@Example.com" } },
+			0, "", "user@Example.com",
+		},
+		{
+			"domain not allowed",
+			domain_conf,
+			url.Values{ "email": { "user@example.org" } },
+			http.StatusForbidden, "", "",
+		},
+		{
+			"domain suffix not allowed",
+			domain_conf,
+			url.Values{ "email": { "user@notexample.com" } },
+			http.StatusForbidden, "", "",
+		},
+		{
+			"domain with display name",
+			domain_conf,
+			url.Values{ "email": { "User <user@example.com>" } },
+			http.StatusUnprocessableEntity, "", "",
+		},
+		{
+			"domain without email",
+			domain_conf,
+			url.Values{},
+			http.StatusUnprocessableEntity, "", "",
+		},
+	}
+
+	for _, test := range tests {
+		user := User{ username: "user", password: "password" }
+		invite_code, _, status_code := checkRegistrationForm(test.conf, test.form, &user)
+
+		if status_code != test.expected_status {
+			t.Errorf("%s: expected status %d. got: %d", test.name, test.expected_status, status_code)
+		}
+
+		if invite_code != test.expected_invite {
+			t.Errorf("%s: expected invite code %s. got: %s", test.name, test.expected_invite, invite_code)
+		}
+
+		if user.email != test.expected_email {
+			t.Errorf("%s: expected email %s. got: %s", test.name, test.expected_email, user.email)
+		}
+	}
+}
+
+func TestValidateRegistrationConfig(t *testing.T) {
+	valid := []RegistrationConfig{
+		{},
+		{ Mode: REGISTRATION_CLOSED },
+		{ Mode: REGISTRATION_DOMAIN, Allowed_domains: []string{ "example.com" } },
+	}
+
+	for _, conf := range valid {
+		if err := validateRegistrationConfig(&conf); err != nil {
+			t.Errorf("expected %+v to be valid. got: %s", conf, err.Error())
+		}
+	}
+
+	invalid := []RegistrationConfig{
+		{ Mode: "invite-only" },
+		{ Mode: REGISTRATION_DOMAIN },
+	}
+
+	for _, conf := range invalid {
+		if err := validateRegistrationConfig(&conf); err == nil {
+			t.Errorf("expected %+v to be invalid", conf)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//go:embed config.json.schema
+var config_schema_json []byte
+
+//the subset of json schema draft-07 used by config.json.schema.
+//annotation keywords like title and description are accepted and ignored
+type jsonSchema struct {
+	Schema               string                 `json:"$schema"`
+	Title                string                 `json:"title"`
+	Description          string                 `json:"description"`
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Required             []string               `json:"required"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []any                  `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+}
+
+type schemaViolation struct {
+	pointer string
+	message string
+}
+
+func (v schemaViolation) Error() string {
+	pointer := v.pointer
+
+	if pointer == "" {
+		pointer = "/"
+	}
+
+	return pointer + ": " + v.message
+}
+
+func parseSchema(schema_json []byte) (*jsonSchema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(schema_json))
+	//catches keywords this validator doesn't implement
+	decoder.DisallowUnknownFields()
+
+	var schema jsonSchema
+	err := decoder.Decode(&schema)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema, nil
+}
+
+//returns every violation of the schema found in document, joined into one error
+func validateAgainstSchema(schema *jsonSchema, document []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+
+	var value any
+	err := decoder.Decode(&value)
+
+	if err != nil {
+		return err
+	}
+
+	violations := []error{}
+	validateSchemaValue(schema, value, "", &violations)
+
+	return errors.Join(violations...)
+}
+
+//escapes a key for use in a json pointer, RFC 6901
+func escapePointerToken(token string) string {
+	token = strings.ReplaceAll(token, "~", "~0")
+	return strings.ReplaceAll(token, "/", "~1")
+}
+
+func jsonTypeName(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+func validateSchemaValue(schema *jsonSchema, value any, pointer string, violations *[]error) {
+	addViolation := func(format string, args ...any) {
+		*violations = append(*violations, schemaViolation{ pointer: pointer, message: fmt.Sprintf(format, args...) })
+	}
+
+	if schema.Type != "" {
+		value_type := jsonTypeName(value)
+
+		//integers are numbers too
+		type_matches := value_type == schema.Type || (schema.Type == "number" && value_type == "integer")
+
+		if !type_matches {
+			addViolation("expected %s but got %s", schema.Type, value_type)
+			return
+		}
+	}
+
+	if len(schema.Enum) != 0 {
+		found := false
+
+		for _, allowed := range schema.Enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			addViolation("must be one of %v", schema.Enum)
+		}
+	}
+
+	switch value := value.(type) {
+	case json.Number:
+		number, _ := value.Float64()
+
+		if schema.Minimum != nil && number < *schema.Minimum {
+			addViolation("must be >= %v", *schema.Minimum)
+		}
+
+		if schema.Maximum != nil && number > *schema.Maximum {
+			addViolation("must be <= %v", *schema.Maximum)
+		}
+	case []any:
+		if schema.Items != nil {
+			for i, item := range value {
+				validateSchemaValue(schema.Items, item, fmt.Sprintf("%s/%d", pointer, i), violations)
+			}
+		}
+	case map[string]any:
+		for _, key := range schema.Required {
+			if _, ok := value[key]; !ok {
+				addViolation("missing required property %q", key)
+			}
+		}
+
+		//sorted so violations are reported in a stable order
+		keys := make([]string, 0, len(value))
+
+		for key := range value {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child_pointer := pointer + "/" + escapePointerToken(key)
+			property, ok := schema.Properties[key]
+
+			if ok {
+				validateSchemaValue(property, value[key], child_pointer, violations)
+			} else if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+				*violations = append(*violations, schemaViolation{ pointer: child_pointer, message: "unknown property" })
+			}
+		}
+	}
+}
+
+func validateConfigJSON(json_str []byte) error {
+	schema, err := parseSchema(config_schema_json)
+
+	if err != nil {
+		return fmt.Errorf("embedded config schema is invalid: %w", err)
+	}
+
+	return validateAgainstSchema(schema, json_str)
+}
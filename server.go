@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DEFAULT_READ_HEADER_TIMEOUT = 10
+const DEFAULT_READ_TIMEOUT = 30
+//long enough for an account export over a slow connection
+const DEFAULT_WRITE_TIMEOUT = 60
+const DEFAULT_IDLE_TIMEOUT = 120
+const DEFAULT_SHUTDOWN_TIMEOUT = 30
+//a few of a typical load balancer's readiness probes
+const DEFAULT_SHUTDOWN_DELAY = 5
+
+//all timeouts are in seconds. zero values fall back to the defaults above
+type ServerConfig struct {
+	Read_header_timeout uint32 `json:"read_header_timeout"`
+	Read_timeout        uint32 `json:"read_timeout"`
+	Write_timeout       uint32 `json:"write_timeout"`
+	Idle_timeout        uint32 `json:"idle_timeout"`
+	//defaults to http.DefaultMaxHeaderBytes
+	Max_header_bytes    uint32 `json:"max_header_bytes"`
+	//how long /readyz reports shutting down after SIGINT or SIGTERM while
+	//still serving, so load balancers take the app out of rotation before
+	//its listeners close
+	Shutdown_delay      uint32 `json:"shutdown_delay"`
+	//how long in flight requests get to finish after the delay
+	Shutdown_timeout    uint32 `json:"shutdown_timeout"`
+}
+
+func secondsOrDefault(seconds uint32, default_seconds uint32) time.Duration {
+	if seconds == 0 {
+		seconds = default_seconds
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+func newHTTPServer(addr string, conf *ServerConfig, handler http.Handler) *http.Server {
+	max_header_bytes := http.DefaultMaxHeaderBytes
+
+	if conf.Max_header_bytes != 0 {
+		max_header_bytes = int(conf.Max_header_bytes)
+	}
+
+	return &http.Server{
+		Addr: addr,
+		Handler: handler,
+		ReadHeaderTimeout: secondsOrDefault(conf.Read_header_timeout, DEFAULT_READ_HEADER_TIMEOUT),
+		ReadTimeout: secondsOrDefault(conf.Read_timeout, DEFAULT_READ_TIMEOUT),
+		WriteTimeout: secondsOrDefault(conf.Write_timeout, DEFAULT_WRITE_TIMEOUT),
+		IdleTimeout: secondsOrDefault(conf.Idle_timeout, DEFAULT_IDLE_TIMEOUT),
+		MaxHeaderBytes: max_header_bytes,
+	}
+}
+
+//runs every server until ctx is cancelled or one of them fails, then
+//keeps serving with /readyz reporting shutting down for shutdown_delay
+//before draining in flight requests on all of them. requests still
+//running after shutdown_timeout are cut off
+func serveUntilShutdown(
+	ctx context.Context,
+	servers []*http.Server,
+	shutdown_delay time.Duration,
+	shutdown_timeout time.Duration,
+) error {
+	serve_errs := make(chan error, len(servers))
+
+	for _, server := range servers {
+		go func() {
+			var err error
+
+			//servers with a TLSConfig take their certificate from GetCertificate
+			if server.TLSConfig != nil {
+				slog.Info("serving https", "addr", server.Addr, "min_version", tls.VersionName(server.TLSConfig.MinVersion))
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				slog.Info("serving http", "addr", server.Addr)
+				err = server.ListenAndServe()
+			}
+
+			if !errors.Is(err, http.ErrServerClosed) {
+				serve_errs <- err
+			}
+		}()
+	}
+
+	var serve_err error
+
+	select {
+	case <-ctx.Done():
+		shutting_down.Store(true)
+		slog.Info("shutdown signal received, reporting not ready", "delay", shutdown_delay.String())
+	case serve_err = <-serve_errs:
+		shutting_down.Store(true)
+		slog.Error("server stopped unexpectedly, shutting down", "err", serve_err.Error())
+	}
+
+	//Shutdown closes the listeners straight away, so probes have to see
+	//the 503 before it's called
+	time.Sleep(shutdown_delay)
+
+	slog.Info("draining connections", "timeout", shutdown_timeout.String())
+
+	shutdown_ctx, cancel := context.WithTimeout(context.Background(), shutdown_timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	shutdown_errs := make([]error, len(servers))
+
+	for i, server := range servers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			shutdown_errs[i] = server.Shutdown(shutdown_ctx)
+		}()
+	}
+
+	wg.Wait()
+
+	err := errors.Join(shutdown_errs...)
+
+	if err != nil {
+		slog.Error("error draining connections, some requests were cut off", "err", err.Error())
+	} else {
+		slog.Info("all connections drained")
+	}
+
+	return errors.Join(serve_err, err)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("error finding a free port: %s", err.Error())
+	}
+
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+//waits until the server accepts connections
+func waitForServer(t *testing.T, addr string) {
+	for i := 0; i < 100; i++ {
+		conn, err := net.Dial("tcp", addr)
+
+		if err == nil {
+			conn.Close()
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("server at %s never started", addr)
+}
+
+func TestNewHTTPServerDefaults(t *testing.T) {
+	server := newHTTPServer("localhost:1800", &ServerConfig{}, nil)
+
+	if server.ReadHeaderTimeout != DEFAULT_READ_HEADER_TIMEOUT * time.Second ||
+	server.ReadTimeout != DEFAULT_READ_TIMEOUT * time.Second ||
+	server.WriteTimeout != DEFAULT_WRITE_TIMEOUT * time.Second ||
+	server.IdleTimeout != DEFAULT_IDLE_TIMEOUT * time.Second ||
+	server.MaxHeaderBytes != http.DefaultMaxHeaderBytes {
+		t.Errorf("unexpected defaults: %+v", server)
+	}
+
+	server = newHTTPServer("localhost:1800", &ServerConfig{ Write_timeout: 5, Max_header_bytes: 4096 }, nil)
+
+	if server.WriteTimeout != 5 * time.Second || server.MaxHeaderBytes != 4096 {
+		t.Errorf("configured values not applied: %+v", server)
+	}
+}
+
+func TestServeUntilShutdownDrains(t *testing.T) {
+	addr := freeAddr(t)
+	started := make(chan bool)
+	release := make(chan bool)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serve_err := make(chan error)
+
+	go func() {
+		serve_err <- serveUntilShutdown(ctx, []*http.Server{ newHTTPServer(addr, &ServerConfig{}, handler) }, 0, 5 * time.Second)
+	}()
+
+	waitForServer(t, addr)
+
+	res_code := make(chan int)
+
+	go func() {
+		res, err := http.Get("http://" + addr)
+
+		if err != nil {
+			res_code <- 0
+			return
+		}
+
+		res.Body.Close()
+		res_code <- res.StatusCode
+	}()
+
+	<-started
+	cancel()
+
+	//give shutdown a moment to start before letting the request finish
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if code := <-res_code; code != http.StatusOK {
+		t.Errorf("in flight request should complete during shutdown, got %d", code)
+	}
+
+	if err := <-serve_err; err != nil {
+		t.Errorf("graceful shutdown should not fail: %s", err.Error())
+	}
+}
+
+func TestServeUntilShutdownDeadline(t *testing.T) {
+	addr := freeAddr(t)
+	started := make(chan bool)
+	release := make(chan bool)
+	defer close(release)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serve_err := make(chan error)
+
+	go func() {
+		serve_err <- serveUntilShutdown(ctx, []*http.Server{ newHTTPServer(addr, &ServerConfig{}, handler) }, 0, 50 * time.Millisecond)
+	}()
+
+	waitForServer(t, addr)
+
+	go http.Get("http://" + addr)
+
+	<-started
+	cancel()
+
+	if err := <-serve_err; err == nil {
+		t.Error("shutdown should report requests cut off by the deadline")
+	}
+}
+
+//with ctx already cancelled the servers go straight into the shutdown
+//delay, during which /readyz is still served and reports shutting down
+func TestServeUntilShutdownDelayReportsNotReady(t *testing.T) {
+	t.Cleanup(func() { shutting_down.Store(false) })
+
+	addr := freeAddr(t)
+	mux := http.NewServeMux()
+	mux.Handle("GET /readyz", handleReadyz([]healthCheck{}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	delay := 500 * time.Millisecond
+	start := time.Now()
+	serve_err := make(chan error)
+
+	go func() {
+		serve_err <- serveUntilShutdown(ctx, []*http.Server{ newHTTPServer(addr, &ServerConfig{}, mux) }, delay, time.Second)
+	}()
+
+	waitForServer(t, addr)
+
+	client := &http.Client{ Transport: &http.Transport{ DisableKeepAlives: true } }
+	res, err := client.Get("http://" + addr + "/readyz")
+
+	if err != nil {
+		t.Fatalf("expected /readyz to be served during the shutdown delay: %s", err.Error())
+	}
+
+	var body ReadinessResponse
+	json.NewDecoder(res.Body).Decode(&body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable || body.Status != "shutting down" {
+		t.Errorf("expected %d shutting down during the delay. got: %d %+v", http.StatusServiceUnavailable, res.StatusCode, body)
+	}
+
+	if err := <-serve_err; err != nil {
+		t.Errorf("graceful shutdown should not fail: %s", err.Error())
+	}
+
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("expected shutdown to wait out the %s delay. returned after: %s", delay, elapsed)
+	}
+
+	if _, err := client.Get("http://" + addr + "/readyz"); err == nil {
+		t.Error("expected the listener to be closed once shutdown returns")
+	}
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -12,23 +15,23 @@ import (
 	_ "github.com/lib/pq"
 )
 
-func OpenDB(host string, port uint16, db_name string, username string, password string) (*sql.DB, error) {
-	conn := fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=disable",
-		host,
-		port,
-		db_name,
-		username,
-		password,
-	)
+func OpenDB(conf *DbConfig) (*sql.DB, error) {
+	//contains the password so must never be logged
+	dsn, err := buildPostgresDSN(conf)
 
-	db, err := sql.Open("postgres", conn)
+	if err != nil {
+		return nil, err
+	}
 
-	return db, err
+	return sql.Open("postgres", dsn)
 }
 
 type User struct {
 	username string
 	password string
+	email string
+	is_admin bool
+	disabled bool
 }
 
 type pgErr struct {
@@ -36,15 +39,74 @@ type pgErr struct {
 	pg_err *pq.Error
 }
 
-func InsertUser(db *sql.DB, user *User) *pgErr {
+var ErrInvalidInviteCode = errors.New("invite code is invalid, expired or used up")
+
+//pg_err is nil when the error didn't come from postgres
+func newPgErr(err error) *pgErr {
+	var pg_err *pq.Error
+	errors.As(err, &pg_err)
+
+	return &pgErr{
+		err: err,
+		pg_err: pg_err,
+	}
+}
+
+//invite_code is redeemed in the same transaction so a failed insert
+//doesn't use it up. pass an empty string when no invite is needed
+func InsertUser(ctx context.Context, db *sql.DB, user *User, invite_code string) *pgErr {
+	ctx, done := startDBCall(ctx, "InsertUser")
+	defer done()
+
+	password_params := hashPassword(user.password)
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return newPgErr(err)
+	}
+
+	defer tx.Rollback()
+
+	var invite_code_id sql.NullInt64
+
+	if invite_code != "" {
+		hash := sha256.Sum256([]byte(invite_code))
+
+		row := tx.QueryRowContext(
+			ctx,
+			`UPDATE InviteCode SET use_count = use_count + 1
+			WHERE code_sha256 = $1 AND NOT revoked AND use_count < max_uses
+			AND (expires_datetime IS NULL OR expires_datetime > NOW())
+			RETURNING id`,
+			hash[:],
+		)
+
+		err = row.Scan(&invite_code_id)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.Info("invalid invite code used", "username", user.username)
+			return &pgErr{ err: ErrInvalidInviteCode }
+		} else if err != nil {
+			slog.Error(
+				"error redeeming invite code",
+				"username", user.username,
+				"err", err.Error(),
+			)
+
+			return newPgErr(err)
+		}
+	}
+
 	query := `
-	INSERT INTO User_ (username, password_params)
-	VALUES ($1, $2)
+	INSERT INTO User_ (username, password_params, email, invite_code_id)
+	VALUES ($1, $2, $3, $4)
 	`
 
-	password_params := hashPassword(user.password)
+	email := sql.NullString{ String: user.email, Valid: user.email != "" }
 
-	_, err := db.Exec(query, user.username, password_params)
+	_, err = tx.ExecContext(ctx, query, user.username, password_params, email, invite_code_id)
 
 	if err != nil {
 		slog.Error(
@@ -53,26 +115,31 @@ func InsertUser(db *sql.DB, user *User) *pgErr {
 			"err", err.Error(),
 		)
 
-		pg_err := err.(*pq.Error)
+		return newPgErr(err)
+	}
+
+	err = tx.Commit()
 
-		return &pgErr{
-			err: err,
-			pg_err: pg_err,
-		}
+	if err != nil {
+		return newPgErr(err)
 	}
 
 	return nil
 }
 
-func GetUser(db *sql.DB, username string) (*User, error) {
+func GetUser(ctx context.Context, db *sql.DB, username string) (*User, error) {
+	ctx, done := startDBCall(ctx, "GetUser")
+	defer done()
+
 	var user User
 
-	row := db.QueryRow(
-		"SELECT username, password_params FROM User_ WHERE username = $1",
+	row := db.QueryRowContext(
+		ctx,
+		"SELECT username, password_params, is_admin, disabled FROM User_ WHERE username = $1",
 		username,
 	)
 
-	err := row.Scan(&user.username, &user.password)
+	err := row.Scan(&user.username, &user.password, &user.is_admin, &user.disabled)
 
 	if err != nil {
 		slog.Error(
@@ -87,7 +154,137 @@ func GetUser(db *sql.DB, username string) (*User, error) {
 	return &user, nil
 }
 
+func UpdateUserPassword(ctx context.Context, db *sql.DB, username string, password_params string) error {
+	ctx, done := startDBCall(ctx, "UpdateUserPassword")
+	defer done()
+
+	query := "UPDATE User_ SET password_params = $1 WHERE username = $2"
+
+	slog.Info(
+		"executing db query",
+		"query", query,
+	)
+
+	_, err := db.ExecContext(ctx, query, password_params, username)
+
+	if err != nil {
+		slog.Error(
+			"error updating user password in db",
+			"username", username,
+			"err", err.Error(),
+		)
+	}
+
+	return err
+}
+
+func GetUserIdentity(ctx context.Context, db *sql.DB, issuer string, subject string) (string, error) {
+	ctx, done := startDBCall(ctx, "GetUserIdentity")
+	defer done()
+
+	row := db.QueryRowContext(
+		ctx,
+		"SELECT username FROM UserIdentity WHERE issuer = $1 AND subject = $2",
+		issuer,
+		subject,
+	)
+
+	var username string
+	err := row.Scan(&username)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error(
+			"error retrieving user identity from db",
+			"issuer", issuer,
+			"err", err.Error(),
+		)
+	}
+
+	return username, err
+}
+
+func InsertUserIdentity(ctx context.Context, db *sql.DB, identity *OidcIdentity) error {
+	ctx, done := startDBCall(ctx, "InsertUserIdentity")
+	defer done()
+
+	query := `
+	INSERT INTO UserIdentity (issuer, subject, username)
+	VALUES ($1, $2, $3)
+	`
+
+	slog.Info(
+		"executing db query",
+		"query", query,
+	)
+
+	_, err := db.ExecContext(ctx, query, identity.issuer, identity.subject, identity.username)
+
+	if err != nil {
+		slog.Error(
+			"error inserting user identity into db",
+			"username", identity.username,
+			"err", err.Error(),
+		)
+	}
+
+	return err
+}
+
+//creates a password-less user and links the identity in one transaction
+func InsertOidcUser(ctx context.Context, db *sql.DB, identity *OidcIdentity) error {
+	ctx, done := startDBCall(ctx, "InsertOidcUser")
+	defer done()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO User_ (username, password_params, email) VALUES ($1, $2, $3)",
+		identity.username,
+		NO_PASSWORD_PARAMS,
+		sql.NullString{ String: identity.email, Valid: identity.email != "" },
+	)
+
+	if err != nil {
+		slog.Error(
+			"error inserting oidc user into db",
+			"username", identity.username,
+			"err", err.Error(),
+		)
+
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO UserIdentity (issuer, subject, username) VALUES ($1, $2, $3)",
+		identity.issuer,
+		identity.subject,
+		identity.username,
+	)
+
+	if err != nil {
+		slog.Error(
+			"error inserting user identity into db",
+			"username", identity.username,
+			"err", err.Error(),
+		)
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
 type Goal struct {
+	id int64
 	title string
 	start_date string
 	end_date string
@@ -97,11 +294,15 @@ type Goal struct {
 }
 
 func GetGoals(
+	ctx context.Context,
 	db *sql.DB,
 	username string,
 	start_date *time.Time,
 	end_date *time.Time,
 ) ([]Goal, error) {
+	ctx, done := startDBCall(ctx, "GetGoals")
+	defer done()
+
 	if start_date == nil {
 		return nil, errors.New("start_date cannot be nil")
 	}
@@ -109,7 +310,7 @@ func GetGoals(
 		return nil, errors.New("end_date cannot be nil")
 	}
 
-	query := `SELECT title, start_date, end_date, completed_datetime, notes
+	query := `SELECT id, title, start_date, end_date, completed_datetime, notes
 	FROM Goal WHERE username = $1 AND (end_date BETWEEN $2 AND $3)`
 
 	slog.Info(
@@ -117,7 +318,8 @@ func GetGoals(
 		"query", query,
 	)
 
-	rows, err := db.Query(
+	rows, err := db.QueryContext(
+		ctx,
 		query,
 		username,
 		start_date,
@@ -136,6 +338,7 @@ func GetGoals(
 		var goal Goal
 
 		err = rows.Scan(
+			&goal.id,
 			&goal.title,
 			&goal.start_date,
 			&goal.end_date,
@@ -143,26 +346,131 @@ func GetGoals(
 			&goal.notes,
 		)
 
+		if err != nil {
+			return nil, err
+		}
+
 		//because postgres returns DATE types as a datetime string,
 		//manually getting just the date string so that it can be
 		//parsed into user's timezone as just date
-		goal.start_date = goal.end_date[:10]
+		goal.start_date = goal.start_date[:10]
 		goal.end_date = goal.end_date[:10]
 
+		goals = append(goals, goal)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return goals, nil
+}
+
+func GetGoalStats(
+	ctx context.Context,
+	db *sql.DB,
+	username string,
+	start_date *time.Time,
+	end_date *time.Time,
+	now *time.Time,
+) (*GoalStats, error) {
+	ctx, done := startDBCall(ctx, "GetGoalStats")
+	defer done()
+
+	if start_date == nil || end_date == nil || now == nil {
+		return nil, errors.New("start_date, end_date and now cannot be nil")
+	}
+
+	//completed_datetime is converted to a UTC date, as end_date is compared
+	//against UTC dates everywhere else
+	days_late := "(completed_datetime AT TIME ZONE 'UTC')::date - end_date"
+
+	queryPeriods := func(period_expr string, from string) ([]GoalStatsPeriod, error) {
+		query := constructGoalStatsQuery(period_expr, from, days_late)
+
+		slog.Info(
+			"executing db query",
+			"query", query,
+		)
+
+		rows, err := db.QueryContext(ctx, query, goalFailedBefore(now), username, start_date, end_date)
+
 		if err != nil {
 			return nil, err
 		}
 
-		goals = append(goals, goal)
+		defer rows.Close()
+
+		return scanGoalStatsRows(rows)
 	}
 
-	err = rows.Err()
+	total, err := queryPeriods("", "Goal")
 
 	if err != nil {
 		return nil, err
 	}
 
-	return goals, nil
+	stats := GoalStats{
+		Start: start_date.Format(time.DateOnly),
+		End: end_date.Format(time.DateOnly),
+		Now: now.Format(time.DateOnly),
+		Total: total[0].GoalStatsSummary,
+	}
+
+	stats.ByWeek, err = queryPeriods("to_char(date_trunc('week', end_date), 'YYYY-MM-DD')", "Goal")
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats.ByMonth, err = queryPeriods("to_char(date_trunc('month', end_date), 'YYYY-MM-DD')", "Goal")
+
+	if err != nil {
+		return nil, err
+	}
+
+	by_tag, err := queryPeriods("tag", GOAL_STATS_BY_TAG_FROM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats.ByTag = goalStatsTags(by_tag)
+
+	return &stats, nil
+}
+
+//marks one of the user's goals complete as of now. sql.ErrNoRows when
+//the user has no such goal or it's already complete
+func CompleteGoal(ctx context.Context, db *sql.DB, username string, id int64) error {
+	ctx, done := startDBCall(ctx, "CompleteGoal")
+	defer done()
+
+	res, err := db.ExecContext(
+		ctx,
+		"UPDATE Goal SET completed_datetime = NOW() WHERE id = $1 AND username = $2 AND completed_datetime IS NULL",
+		id,
+		username,
+	)
+
+	if err != nil {
+		slog.Error(
+			"error completing goal in db",
+			"id", id,
+			"username", username,
+			"err", err.Error(),
+		)
+
+		return err
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
 }
 
 type GoalInsert struct {
@@ -170,9 +478,13 @@ type GoalInsert struct {
 	start_date *time.Time
 	end_date *time.Time
 	notes string
+	tags []string
 }
 
-func InsertGoals(db *sql.DB, username string, goals *[]GoalInsert) error {
+func InsertGoals(ctx context.Context, db *sql.DB, username string, goals *[]GoalInsert) error {
+	ctx, done := startDBCall(ctx, "InsertGoals")
+	defer done()
+
 	query, params, err := constructGoalInsertQuery(username, goals)
 
 	if err != nil {
@@ -184,21 +496,85 @@ func InsertGoals(db *sql.DB, username string, goals *[]GoalInsert) error {
 		return err
 	}
 
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error(
+			"error beginning transaction",
+			"err", err.Error(),
+		)
+
+		return err
+	}
+
+	defer tx.Rollback()
+
 	slog.Info(
 		"executing db query",
 		"query", query,
 	)
 
-	_, err = db.Exec(query, *params...)
+	//postgres returns the ids of a multi-row insert in the order of its
+	//VALUES, so goal_ids[i] is the id of (*goals)[i]
+	rows, err := tx.QueryContext(ctx, query + " RETURNING id", *params...)
 
 	if err != nil {
 		slog.Error(
 			"error posting goals to db",
 			"err", err.Error(),
 		)
+
+		return err
 	}
 
-	return err
+	goal_ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+
+		goal_ids = append(goal_ids, id)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	tag_goal_ids := []int64{}
+	tags := []string{}
+
+	for i, goal := range *goals {
+		for _, tag := range goal.tags {
+			tag_goal_ids = append(tag_goal_ids, goal_ids[i])
+			tags = append(tags, tag)
+		}
+	}
+
+	if len(tags) != 0 {
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO GoalTag (goal_id, tag) SELECT * FROM unnest($1::int[], $2::varchar[])",
+			pq.Array(tag_goal_ids),
+			pq.Array(tags),
+		)
+
+		if err != nil {
+			slog.Error(
+				"error posting goal tags to db",
+				"err", err.Error(),
+			)
+
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 //returns the query string and the params
@@ -233,27 +609,39 @@ func constructGoalInsertQuery(username string, goals *[]GoalInsert) (string, *[]
 	return query.String(), &params, nil
 }
 
-func DeleteSessionId(db *sql.DB, session_id_sha256 [32]byte) error {
-	query := "DELETE FROM SessionId WHERE session_id_sha256=$1"
+//returns the username the session belonged to, empty if there was no session
+func DeleteSessionId(ctx context.Context, db *sql.DB, session_id_sha256 [32]byte) (string, error) {
+	ctx, done := startDBCall(ctx, "DeleteSessionId")
+	defer done()
+
+	query := "DELETE FROM SessionId WHERE session_id_sha256=$1 RETURNING username"
 
 	slog.Info(
 		"executing db query",
 		"query", query,
 	)
 
-	_, err := db.Exec(query, session_id_sha256[:])
+	var username string
+	err := db.QueryRowContext(ctx, query, session_id_sha256[:]).Scan(&username)
 
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
 		slog.Error(
 			"error deleting session id from db",
 			"err", err.Error(),
 		)
+
+		return "", err
 	}
 
-	return err
+	return username, nil
 }
 
-func UpsertSessionId(db *sql.DB, username string, session_id_sha256 [32]byte) error {
+func UpsertSessionId(ctx context.Context, db *sql.DB, username string, session_id_sha256 [32]byte) error {
+	ctx, done := startDBCall(ctx, "UpsertSessionId")
+	defer done()
+
 	if username == "" {
 		return errors.New("empty username when attempting to insert auth token")
 	}
@@ -270,7 +658,7 @@ func UpsertSessionId(db *sql.DB, username string, session_id_sha256 [32]byte) er
 		"query", query,
 	)
 
-	_, err := db.Exec(query, username, session_id_sha256[:])
+	_, err := db.ExecContext(ctx, query, username, session_id_sha256[:])
 
 	if err != nil {
 		slog.Error(
@@ -283,14 +671,20 @@ func UpsertSessionId(db *sql.DB, username string, session_id_sha256 [32]byte) er
 	return err
 }
 
-func GetSessionId(db *sql.DB, session_id_sha256 [32]byte) (string, error) {
-	row := db.QueryRow(
-		"SELECT username FROM SessionId WHERE session_id_sha256 = $1",
+//only returns sessions belonging to users that are not disabled
+func GetSessionId(ctx context.Context, db *sql.DB, session_id_sha256 [32]byte) (username string, is_admin bool, err error) {
+	ctx, done := startDBCall(ctx, "GetSessionId")
+	defer done()
+
+	row := db.QueryRowContext(
+		ctx,
+		`SELECT s.username, u.is_admin FROM SessionId s
+		JOIN User_ u ON u.username = s.username
+		WHERE s.session_id_sha256 = $1 AND NOT u.disabled`,
 		session_id_sha256[:],
 	)
 
-	var username string
-	err := row.Scan(&username)
+	err = row.Scan(&username, &is_admin)
 
 	if err != nil {
 		slog.Error(
@@ -298,9 +692,648 @@ func GetSessionId(db *sql.DB, session_id_sha256 [32]byte) (string, error) {
 			"err", err.Error(),
 		)
 
-		return "", err
+		return "", false, err
 	}
 
-	return username, nil
+	return username, is_admin, nil
+}
+
+func DeleteUserSessions(ctx context.Context, db *sql.DB, username string) error {
+	ctx, done := startDBCall(ctx, "DeleteUserSessions")
+	defer done()
+
+	query := "DELETE FROM SessionId WHERE username = $1"
+
+	slog.Info(
+		"executing db query",
+		"query", query,
+	)
+
+	_, err := db.ExecContext(ctx, query, username)
+
+	if err != nil {
+		slog.Error(
+			"error deleting user sessions from db",
+			"username", username,
+			"err", err.Error(),
+		)
+	}
+
+	return err
 }
 
+func UpdateUserLastLogin(ctx context.Context, db *sql.DB, username string) error {
+	ctx, done := startDBCall(ctx, "UpdateUserLastLogin")
+	defer done()
+
+	query := "UPDATE User_ SET last_login_datetime = NOW() WHERE username = $1"
+
+	_, err := db.ExecContext(ctx, query, username)
+
+	if err != nil {
+		slog.Error(
+			"error updating user last login in db",
+			"username", username,
+			"err", err.Error(),
+		)
+	}
+
+	return err
+}
+
+type UserSummary struct {
+	Username string
+	IsAdmin bool
+	Disabled bool
+	GoalCount int
+	LastLogin *time.Time
+}
+
+func GetUserSummaries(ctx context.Context, db *sql.DB) ([]UserSummary, error) {
+	ctx, done := startDBCall(ctx, "GetUserSummaries")
+	defer done()
+
+	query := `SELECT u.username, u.is_admin, u.disabled, u.last_login_datetime, COUNT(g.id)
+	FROM User_ u LEFT JOIN Goal g ON g.username = u.username
+	GROUP BY u.username
+	ORDER BY u.username`
+
+	slog.Info(
+		"executing db query",
+		"query", query,
+	)
+
+	rows, err := db.QueryContext(ctx, query)
+
+	if err != nil {
+		slog.Error("error retrieving user summaries from db", "err", err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var users []UserSummary
+
+	for rows.Next() {
+		var user UserSummary
+
+		err = rows.Scan(
+			&user.Username,
+			&user.IsAdmin,
+			&user.Disabled,
+			&user.LastLogin,
+			&user.GoalCount,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+//disabling a user also ends their session. returns sql.ErrNoRows if the user doesn't exist
+func SetUserDisabled(ctx context.Context, db *sql.DB, username string, disabled bool) error {
+	ctx, done := startDBCall(ctx, "SetUserDisabled")
+	defer done()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE User_ SET disabled = $1 WHERE username = $2", disabled, username)
+
+	if err != nil {
+		slog.Error(
+			"error updating user disabled in db",
+			"username", username,
+			"err", err.Error(),
+		)
+
+		return err
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if disabled {
+		_, err = tx.ExecContext(ctx, "DELETE FROM SessionId WHERE username = $1", username)
+
+		if err != nil {
+			slog.Error(
+				"error deleting user sessions from db",
+				"username", username,
+				"err", err.Error(),
+			)
+
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+const DELETED_USERNAME = "[deleted]"
+
+//removes the user in one transaction. goals, sessions and identities are
+//removed by cascade, rows that only mention the user are anonymised.
+//returns sql.ErrNoRows if the user doesn't exist
+func DeleteUser(ctx context.Context, db *sql.DB, username string) error {
+	ctx, done := startDBCall(ctx, "DeleteUser")
+	defer done()
+
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	anonymise_queries := []string{
+		"UPDATE InviteCode SET created_by = $1 WHERE created_by = $2",
+		"UPDATE AuditEvent SET username = $1, ip = '', user_agent = '' WHERE username = $2",
+	}
+
+	for _, query := range anonymise_queries {
+		_, err = tx.ExecContext(ctx, query, DELETED_USERNAME, username)
+
+		if err != nil {
+			slog.Error(
+				"error anonymising user data in db",
+				"username", username,
+				"query", query,
+				"err", err.Error(),
+			)
+
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM User_ WHERE username = $1", username)
+
+	if err != nil {
+		slog.Error(
+			"error deleting user from db",
+			"username", username,
+			"err", err.Error(),
+		)
+
+		return err
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+//grants admin to the given usernames. used to bootstrap admins from config
+func PromoteAdmins(ctx context.Context, db *sql.DB, usernames []string) error {
+	ctx, done := startDBCall(ctx, "PromoteAdmins")
+	defer done()
+
+	query := "UPDATE User_ SET is_admin = TRUE WHERE username = ANY($1)"
+
+	slog.Info(
+		"executing db query",
+		"query", query,
+	)
+
+	_, err := db.ExecContext(ctx, query, pq.Array(usernames))
+
+	if err != nil {
+		slog.Error("error promoting admins in db", "err", err.Error())
+	}
+
+	return err
+}
+
+type InviteCodeSummary struct {
+	Id int64
+	CreatedBy string
+	Created time.Time
+	Expires *time.Time
+	MaxUses int
+	UseCount int
+	Revoked bool
+	Note string
+}
+
+func InsertInviteCode(
+	ctx context.Context,
+	db *sql.DB,
+	code_sha256 [32]byte,
+	created_by string,
+	expires *time.Time,
+	max_uses int,
+	note string,
+) error {
+	ctx, done := startDBCall(ctx, "InsertInviteCode")
+	defer done()
+
+	query := `
+	INSERT INTO InviteCode (code_sha256, created_by, expires_datetime, max_uses, note)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+
+	slog.Info(
+		"executing db query",
+		"query", query,
+	)
+
+	_, err := db.ExecContext(ctx, query, code_sha256[:], created_by, expires, max_uses, note)
+
+	if err != nil {
+		slog.Error(
+			"error inserting invite code into db",
+			"created_by", created_by,
+			"err", err.Error(),
+		)
+	}
+
+	return err
+}
+
+func GetInviteCodes(ctx context.Context, db *sql.DB) ([]InviteCodeSummary, error) {
+	ctx, done := startDBCall(ctx, "GetInviteCodes")
+	defer done()
+
+	query := `SELECT id, created_by, created_datetime, expires_datetime, max_uses, use_count, revoked, note
+	FROM InviteCode ORDER BY created_datetime DESC`
+
+	rows, err := db.QueryContext(ctx, query)
+
+	if err != nil {
+		slog.Error("error retrieving invite codes from db", "err", err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var invites []InviteCodeSummary
+
+	for rows.Next() {
+		var invite InviteCodeSummary
+
+		err = rows.Scan(
+			&invite.Id,
+			&invite.CreatedBy,
+			&invite.Created,
+			&invite.Expires,
+			&invite.MaxUses,
+			&invite.UseCount,
+			&invite.Revoked,
+			&invite.Note,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		invites = append(invites, invite)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+//returns sql.ErrNoRows if the invite doesn't exist
+func RevokeInviteCode(ctx context.Context, db *sql.DB, id int64) error {
+	ctx, done := startDBCall(ctx, "RevokeInviteCode")
+	defer done()
+
+	res, err := db.ExecContext(ctx, "UPDATE InviteCode SET revoked = TRUE WHERE id = $1", id)
+
+	if err != nil {
+		slog.Error(
+			"error revoking invite code in db",
+			"id", id,
+			"err", err.Error(),
+		)
+
+		return err
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+type AccountExport struct {
+	Exported time.Time `json:"exported"`
+	Account struct {
+		Username string `json:"username"`
+		Email *string `json:"email"`
+		IsAdmin bool `json:"is_admin"`
+		Disabled bool `json:"disabled"`
+		LastLogin *time.Time `json:"last_login"`
+	} `json:"account"`
+	Goals []GoalExport `json:"goals"`
+	Sessions []SessionExport `json:"sessions"`
+	Identities []IdentityExport `json:"identities"`
+	AuditEvents []AuditEvent `json:"audit_events"`
+}
+
+type GoalExport struct {
+	Id int64 `json:"id"`
+	Title string `json:"title"`
+	StartDate string `json:"start_date"`
+	EndDate string `json:"end_date"`
+	Completed *time.Time `json:"completed"`
+	Notes *string `json:"notes"`
+	Tags []string `json:"tags"`
+}
+
+type SessionExport struct {
+	SessionIdSha256 string `json:"session_id_sha256"`
+}
+
+type IdentityExport struct {
+	Issuer string `json:"issuer"`
+	Subject string `json:"subject"`
+	Created time.Time `json:"created"`
+}
+
+//collects everything stored about a user. reads happen in one
+//repeatable read transaction so the export is a consistent snapshot
+func GetAccountExport(ctx context.Context, db *sql.DB, username string) (*AccountExport, error) {
+	ctx, done := startDBCall(ctx, "GetAccountExport")
+	defer done()
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ Isolation: sql.LevelRepeatableRead, ReadOnly: true })
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	export := AccountExport{
+		Exported: time.Now().UTC(),
+		Goals: []GoalExport{},
+		Sessions: []SessionExport{},
+		Identities: []IdentityExport{},
+		AuditEvents: []AuditEvent{},
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT username, email, is_admin, disabled, last_login_datetime FROM User_ WHERE username = $1",
+		username,
+	).Scan(
+		&export.Account.Username,
+		&export.Account.Email,
+		&export.Account.IsAdmin,
+		&export.Account.Disabled,
+		&export.Account.LastLogin,
+	)
+
+	if err != nil {
+		slog.Error("error exporting user", "username", username, "err", err.Error())
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT id, title, start_date, end_date, completed_datetime, notes,
+			ARRAY(SELECT tag FROM GoalTag WHERE goal_id = Goal.id ORDER BY tag)
+		FROM Goal WHERE username = $1 ORDER BY id`,
+		username,
+	)
+
+	if err != nil {
+		slog.Error("error exporting goals", "username", username, "err", err.Error())
+		return nil, err
+	}
+
+	for rows.Next() {
+		var goal GoalExport
+		var start_date, end_date time.Time
+
+		err = rows.Scan(&goal.Id, &goal.Title, &start_date, &end_date, &goal.Completed, &goal.Notes, pq.Array(&goal.Tags))
+
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if goal.Tags == nil {
+			goal.Tags = []string{}
+		}
+
+		goal.StartDate = start_date.Format(time.DateOnly)
+		goal.EndDate = end_date.Format(time.DateOnly)
+		export.Goals = append(export.Goals, goal)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, "SELECT session_id_sha256 FROM SessionId WHERE username = $1", username)
+
+	if err != nil {
+		slog.Error("error exporting sessions", "username", username, "err", err.Error())
+		return nil, err
+	}
+
+	for rows.Next() {
+		var hash []byte
+
+		if err = rows.Scan(&hash); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		export.Sessions = append(export.Sessions, SessionExport{ SessionIdSha256: hex.EncodeToString(hash) })
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(
+		ctx,
+		"SELECT issuer, subject, created_datetime FROM UserIdentity WHERE username = $1 ORDER BY created_datetime",
+		username,
+	)
+
+	if err != nil {
+		slog.Error("error exporting identities", "username", username, "err", err.Error())
+		return nil, err
+	}
+
+	for rows.Next() {
+		var identity IdentityExport
+
+		if err = rows.Scan(&identity.Issuer, &identity.Subject, &identity.Created); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		export.Identities = append(export.Identities, identity)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(
+		ctx,
+		`SELECT id, created_datetime, event_type, username, ip, user_agent, outcome, detail
+		FROM AuditEvent WHERE username = $1 ORDER BY created_datetime`,
+		username,
+	)
+
+	if err != nil {
+		slog.Error("error exporting audit events", "username", username, "err", err.Error())
+		return nil, err
+	}
+
+	export.AuditEvents, err = scanAuditEvents(rows)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+func InsertAuditEvent(ctx context.Context, db *sql.DB, event *AuditEvent) error {
+	ctx, done := startDBCall(ctx, "InsertAuditEvent")
+	defer done()
+
+	query := `
+	INSERT INTO AuditEvent (event_type, username, ip, user_agent, outcome, detail)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err := db.ExecContext(
+		ctx,
+		query,
+		event.EventType,
+		event.Username,
+		event.Ip,
+		event.UserAgent,
+		event.Outcome,
+		event.Detail,
+	)
+
+	if err != nil {
+		slog.Error(
+			"error inserting audit event into db",
+			"event_type", event.EventType,
+			"username", event.Username,
+			"err", err.Error(),
+		)
+	}
+
+	return err
+}
+
+//closes rows once read
+func scanAuditEvents(rows *sql.Rows) ([]AuditEvent, error) {
+	defer rows.Close()
+
+	events := []AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+
+		err := rows.Scan(
+			&event.Id,
+			&event.Created,
+			&event.EventType,
+			&event.Username,
+			&event.Ip,
+			&event.UserAgent,
+			&event.Outcome,
+			&event.Detail,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	err := rows.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func GetAuditEvents(ctx context.Context, db *sql.DB, filter *AuditFilter) ([]AuditEvent, error) {
+	ctx, done := startDBCall(ctx, "GetAuditEvents")
+	defer done()
+
+	query, params := constructAuditQuery(filter)
+
+	slog.Info(
+		"executing db query",
+		"query", query,
+	)
+
+	rows, err := db.QueryContext(ctx, query, params...)
+
+	if err != nil {
+		slog.Error("error retrieving audit events from db", "err", err.Error())
+		return nil, err
+	}
+
+	return scanAuditEvents(rows)
+}
+
+//the highest migration version applied, 0 if none are recorded
+func GetSchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	ctx, done := startDBCall(ctx, "GetSchemaVersion")
+	defer done()
+
+	var version int
+
+	row := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM SchemaMigration")
+	err := row.Scan(&version)
+
+	if err != nil {
+		slog.Error("error retrieving schema version from db", "err", err.Error())
+		return 0, err
+	}
+
+	return version, nil
+}
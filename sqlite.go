@@ -0,0 +1,1037 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+//go:embed db/sqlite/migrations/*.sql
+var sqlite_migration_files embed.FS
+
+const SQLITE_MIGRATIONS_DIR = "db/sqlite/migrations"
+
+//matches strftime('%Y-%m-%d %H:%M:%f', 'now') so timestamps written from
+//go and from sql defaults compare correctly as strings
+const SQLITE_TIMESTAMP_FORMAT = "2006-01-02 15:04:05.000"
+const SQLITE_NOW = "strftime('%Y-%m-%d %H:%M:%f', 'now')"
+
+//foreign keys are off by default in sqlite and needed for the cascades.
+//immediate transactions take the write lock up front, so concurrent
+//writers wait on the busy timeout instead of failing part way through
+const SQLITE_DSN_PARAMS = "_foreign_keys=1&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+
+func OpenSqliteDB(db_path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", "file:" + db_path + "?" + SQLITE_DSN_PARAMS)
+}
+
+func sqliteTimestamp(t time.Time) string {
+	return t.UTC().Format(SQLITE_TIMESTAMP_FORMAT)
+}
+
+//nil stays NULL
+func sqliteNullTimestamp(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+
+	return sqliteTimestamp(*t)
+}
+
+func sqliteDate(t time.Time) string {
+	return truncateToDate(t).Format(time.DateOnly)
+}
+
+func isSqliteUniqueViolation(err error) bool {
+	var sqlite_err sqlite3.Error
+
+	if !errors.As(err, &sqlite_err) {
+		return false
+	}
+
+	return sqlite_err.ExtendedCode == sqlite3.ErrConstraintUnique ||
+	sqlite_err.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
+
+//applies the embedded migrations newer than the recorded schema version,
+//each in its own transaction along with recording its version
+func applySqliteMigrations(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS SchemaMigration (
+		version INTEGER PRIMARY KEY,
+		applied_datetime TIMESTAMP NOT NULL DEFAULT (` + SQLITE_NOW + `)
+	)`)
+
+	if err != nil {
+		slog.Error("error creating schema migration table", "err", err.Error())
+		return err
+	}
+
+	applied, err := newSqliteStore(db).GetSchemaVersion(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	entries, err := fs.ReadDir(sqlite_migration_files, SQLITE_MIGRATIONS_DIR)
+
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		version, err := migrationVersion(entry.Name())
+
+		if err != nil {
+			return err
+		}
+
+		if version <= applied {
+			continue
+		}
+
+		migration, err := sqlite_migration_files.ReadFile(path.Join(SQLITE_MIGRATIONS_DIR, entry.Name()))
+
+		if err != nil {
+			return err
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, string(migration))
+
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO SchemaMigration (version) VALUES (?)", version)
+		}
+
+		if err == nil {
+			err = tx.Commit()
+		}
+
+		if err != nil {
+			tx.Rollback()
+			slog.Error("error applying sqlite migration", "migration", entry.Name(), "err", err.Error())
+
+			return fmt.Errorf("applying %s: %w", entry.Name(), err)
+		}
+
+		slog.Info("applied sqlite migration", "migration", entry.Name())
+	}
+
+	return nil
+}
+
+//the Store backed by a sqlite database file. queries mirror those in
+//sql.go, adjusted for sqlite's placeholders, dates and timestamps
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSqliteStore(db *sql.DB) *sqliteStore {
+	return &sqliteStore{ db: db }
+}
+
+func (s *sqliteStore) InsertUser(ctx context.Context, user *User, invite_code string) error {
+	ctx, done := startDBCall(ctx, "InsertUser")
+	defer done()
+
+	password_params := hashPassword(user.password)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var invite_code_id sql.NullInt64
+
+	if invite_code != "" {
+		hash := sha256.Sum256([]byte(invite_code))
+
+		row := tx.QueryRowContext(
+			ctx,
+			`UPDATE InviteCode SET use_count = use_count + 1
+			WHERE code_sha256 = ? AND NOT revoked AND use_count < max_uses
+			AND (expires_datetime IS NULL OR expires_datetime > ` + SQLITE_NOW + `)
+			RETURNING id`,
+			hash[:],
+		)
+
+		err = row.Scan(&invite_code_id)
+
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.Info("invalid invite code used", "username", user.username)
+			return ErrInvalidInviteCode
+		} else if err != nil {
+			slog.Error(
+				"error redeeming invite code",
+				"username", user.username,
+				"err", err.Error(),
+			)
+
+			return err
+		}
+	}
+
+	email := sql.NullString{ String: user.email, Valid: user.email != "" }
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO User_ (username, password_params, email, invite_code_id) VALUES (?, ?, ?, ?)",
+		user.username,
+		password_params,
+		email,
+		invite_code_id,
+	)
+
+	if isSqliteUniqueViolation(err) {
+		return ErrUsernameTaken
+	} else if err != nil {
+		slog.Error(
+			"error inserting user into db",
+			"username", user.username,
+			"err", err.Error(),
+		)
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) GetUser(ctx context.Context, username string) (*User, error) {
+	ctx, done := startDBCall(ctx, "GetUser")
+	defer done()
+
+	var user User
+
+	row := s.db.QueryRowContext(
+		ctx,
+		"SELECT username, password_params, is_admin, disabled FROM User_ WHERE username = ?",
+		username,
+	)
+
+	err := row.Scan(&user.username, &user.password, &user.is_admin, &user.disabled)
+
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("error retrieving user from db", "username", username, "err", err.Error())
+		}
+
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *sqliteStore) exec(ctx context.Context, operation string, query string, args ...any) (sql.Result, error) {
+	ctx, done := startDBCall(ctx, operation)
+	defer done()
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+
+	if err != nil {
+		slog.Error("error executing db query", "operation", operation, "err", err.Error())
+	}
+
+	return res, err
+}
+
+//sql.ErrNoRows when the statement changed nothing
+func requireAffected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *sqliteStore) UpdateUserPassword(ctx context.Context, username string, password_params string) error {
+	_, err := s.exec(
+		ctx,
+		"UpdateUserPassword",
+		"UPDATE User_ SET password_params = ? WHERE username = ?",
+		password_params,
+		username,
+	)
+
+	return err
+}
+
+func (s *sqliteStore) UpdateUserLastLogin(ctx context.Context, username string) error {
+	_, err := s.exec(
+		ctx,
+		"UpdateUserLastLogin",
+		"UPDATE User_ SET last_login_datetime = " + SQLITE_NOW + " WHERE username = ?",
+		username,
+	)
+
+	return err
+}
+
+func (s *sqliteStore) GetUserSummaries(ctx context.Context) ([]UserSummary, error) {
+	ctx, done := startDBCall(ctx, "GetUserSummaries")
+	defer done()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT u.username, u.is_admin, u.disabled, u.last_login_datetime, COUNT(g.id)
+		FROM User_ u LEFT JOIN Goal g ON g.username = u.username
+		GROUP BY u.username
+		ORDER BY u.username`,
+	)
+
+	if err != nil {
+		slog.Error("error retrieving user summaries from db", "err", err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var users []UserSummary
+
+	for rows.Next() {
+		var user UserSummary
+
+		err = rows.Scan(&user.Username, &user.IsAdmin, &user.Disabled, &user.LastLogin, &user.GoalCount)
+
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (s *sqliteStore) SetUserDisabled(ctx context.Context, username string, disabled bool) error {
+	ctx, done := startDBCall(ctx, "SetUserDisabled")
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = requireAffected(tx.ExecContext(ctx, "UPDATE User_ SET disabled = ? WHERE username = ?", disabled, username))
+
+	if err != nil {
+		return err
+	}
+
+	if disabled {
+		_, err = tx.ExecContext(ctx, "DELETE FROM SessionId WHERE username = ?", username)
+
+		if err != nil {
+			slog.Error("error deleting user sessions from db", "username", username, "err", err.Error())
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) DeleteUser(ctx context.Context, username string) error {
+	ctx, done := startDBCall(ctx, "DeleteUser")
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	anonymise_queries := []string{
+		"UPDATE InviteCode SET created_by = ? WHERE created_by = ?",
+		"UPDATE AuditEvent SET username = ?, ip = '', user_agent = '' WHERE username = ?",
+	}
+
+	for _, query := range anonymise_queries {
+		_, err = tx.ExecContext(ctx, query, DELETED_USERNAME, username)
+
+		if err != nil {
+			slog.Error("error anonymising user data in db", "username", username, "err", err.Error())
+			return err
+		}
+	}
+
+	err = requireAffected(tx.ExecContext(ctx, "DELETE FROM User_ WHERE username = ?", username))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) PromoteAdmins(ctx context.Context, usernames []string) error {
+	if len(usernames) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(usernames)), ", ")
+	args := make([]any, len(usernames))
+
+	for i, username := range usernames {
+		args[i] = username
+	}
+
+	_, err := s.exec(
+		ctx,
+		"PromoteAdmins",
+		"UPDATE User_ SET is_admin = TRUE WHERE username IN (" + placeholders + ")",
+		args...,
+	)
+
+	return err
+}
+
+func (s *sqliteStore) GetAccountExport(ctx context.Context, username string) (*AccountExport, error) {
+	ctx, done := startDBCall(ctx, "GetAccountExport")
+	defer done()
+
+	//sqlite transactions are serializable, so this is a consistent snapshot
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	export := AccountExport{
+		Exported: time.Now().UTC(),
+		Goals: []GoalExport{},
+		Sessions: []SessionExport{},
+		Identities: []IdentityExport{},
+		AuditEvents: []AuditEvent{},
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT username, email, is_admin, disabled, last_login_datetime FROM User_ WHERE username = ?",
+		username,
+	).Scan(
+		&export.Account.Username,
+		&export.Account.Email,
+		&export.Account.IsAdmin,
+		&export.Account.Disabled,
+		&export.Account.LastLogin,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT id, title, start_date, end_date, completed_datetime, notes,
+			(SELECT group_concat(tag) FROM (SELECT tag FROM GoalTag WHERE goal_id = Goal.id ORDER BY tag))
+		FROM Goal WHERE username = ? ORDER BY id`,
+		username,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var goal GoalExport
+		var start_date, end_date time.Time
+		//comma separated, which parseGoalTags never leaves in a tag
+		var tags sql.NullString
+
+		err = rows.Scan(&goal.Id, &goal.Title, &start_date, &end_date, &goal.Completed, &goal.Notes, &tags)
+
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		goal.Tags = []string{}
+
+		if tags.Valid {
+			goal.Tags = strings.Split(tags.String, ",")
+		}
+
+		goal.StartDate = start_date.Format(time.DateOnly)
+		goal.EndDate = end_date.Format(time.DateOnly)
+		export.Goals = append(export.Goals, goal)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, "SELECT session_id_sha256 FROM SessionId WHERE username = ?", username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var hash []byte
+
+		if err = rows.Scan(&hash); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		export.Sessions = append(export.Sessions, SessionExport{ SessionIdSha256: hex.EncodeToString(hash) })
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(
+		ctx,
+		"SELECT issuer, subject, created_datetime FROM UserIdentity WHERE username = ? ORDER BY created_datetime",
+		username,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var identity IdentityExport
+
+		if err = rows.Scan(&identity.Issuer, &identity.Subject, &identity.Created); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		export.Identities = append(export.Identities, identity)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(
+		ctx,
+		`SELECT id, created_datetime, event_type, username, ip, user_agent, outcome, detail
+		FROM AuditEvent WHERE username = ? ORDER BY created_datetime`,
+		username,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	export.AuditEvents, err = scanAuditEvents(rows)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+func (s *sqliteStore) GetUserIdentity(ctx context.Context, issuer string, subject string) (string, error) {
+	ctx, done := startDBCall(ctx, "GetUserIdentity")
+	defer done()
+
+	var username string
+
+	err := s.db.QueryRowContext(
+		ctx,
+		"SELECT username FROM UserIdentity WHERE issuer = ? AND subject = ?",
+		issuer,
+		subject,
+	).Scan(&username)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.Error("error retrieving user identity from db", "issuer", issuer, "err", err.Error())
+	}
+
+	return username, err
+}
+
+func (s *sqliteStore) InsertUserIdentity(ctx context.Context, identity *OidcIdentity) error {
+	_, err := s.exec(
+		ctx,
+		"InsertUserIdentity",
+		"INSERT INTO UserIdentity (issuer, subject, username) VALUES (?, ?, ?)",
+		identity.issuer,
+		identity.subject,
+		identity.username,
+	)
+
+	return err
+}
+
+func (s *sqliteStore) InsertOidcUser(ctx context.Context, identity *OidcIdentity) error {
+	ctx, done := startDBCall(ctx, "InsertOidcUser")
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO User_ (username, password_params, email) VALUES (?, ?, ?)",
+		identity.username,
+		NO_PASSWORD_PARAMS,
+		sql.NullString{ String: identity.email, Valid: identity.email != "" },
+	)
+
+	if err != nil {
+		slog.Error("error inserting oidc user into db", "username", identity.username, "err", err.Error())
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO UserIdentity (issuer, subject, username) VALUES (?, ?, ?)",
+		identity.issuer,
+		identity.subject,
+		identity.username,
+	)
+
+	if err != nil {
+		slog.Error("error inserting user identity into db", "username", identity.username, "err", err.Error())
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) UpsertSessionId(ctx context.Context, username string, session_id_sha256 [32]byte) error {
+	if username == "" {
+		return errors.New("empty username when attempting to insert auth token")
+	}
+
+	_, err := s.exec(
+		ctx,
+		"UpsertSessionId",
+		`INSERT INTO SessionId (username, session_id_sha256) VALUES (?, ?)
+		ON CONFLICT (username) DO UPDATE SET session_id_sha256 = excluded.session_id_sha256`,
+		username,
+		session_id_sha256[:],
+	)
+
+	return err
+}
+
+func (s *sqliteStore) GetSessionId(ctx context.Context, session_id_sha256 [32]byte) (username string, is_admin bool, err error) {
+	ctx, done := startDBCall(ctx, "GetSessionId")
+	defer done()
+
+	err = s.db.QueryRowContext(
+		ctx,
+		`SELECT s.username, u.is_admin FROM SessionId s
+		JOIN User_ u ON u.username = s.username
+		WHERE s.session_id_sha256 = ? AND NOT u.disabled`,
+		session_id_sha256[:],
+	).Scan(&username, &is_admin)
+
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("error retrieving session id from db", "err", err.Error())
+		}
+
+		return "", false, err
+	}
+
+	return username, is_admin, nil
+}
+
+func (s *sqliteStore) DeleteSessionId(ctx context.Context, session_id_sha256 [32]byte) (string, error) {
+	ctx, done := startDBCall(ctx, "DeleteSessionId")
+	defer done()
+
+	var username string
+
+	err := s.db.QueryRowContext(
+		ctx,
+		"DELETE FROM SessionId WHERE session_id_sha256 = ? RETURNING username",
+		session_id_sha256[:],
+	).Scan(&username)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		slog.Error("error deleting session id from db", "err", err.Error())
+		return "", err
+	}
+
+	return username, nil
+}
+
+func (s *sqliteStore) DeleteUserSessions(ctx context.Context, username string) error {
+	_, err := s.exec(ctx, "DeleteUserSessions", "DELETE FROM SessionId WHERE username = ?", username)
+	return err
+}
+
+func (s *sqliteStore) GetGoals(
+	ctx context.Context,
+	username string,
+	start_date *time.Time,
+	end_date *time.Time,
+) ([]Goal, error) {
+	ctx, done := startDBCall(ctx, "GetGoals")
+	defer done()
+
+	if start_date == nil {
+		return nil, errors.New("start_date cannot be nil")
+	}
+	if end_date == nil {
+		return nil, errors.New("end_date cannot be nil")
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, title, start_date, end_date, completed_datetime, notes
+		FROM Goal WHERE username = ? AND (end_date BETWEEN ? AND ?)`,
+		username,
+		sqliteDate(*start_date),
+		sqliteDate(*end_date),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var goals []Goal
+
+	for rows.Next() {
+		var goal Goal
+		var start, end time.Time
+		var notes sql.NullString
+
+		err = rows.Scan(&goal.id, &goal.title, &start, &end, &goal.completed_datetime, &notes)
+
+		if err != nil {
+			return nil, err
+		}
+
+		goal.start_date = start.Format(time.DateOnly)
+		goal.end_date = end.Format(time.DateOnly)
+		goal.notes = notes.String
+
+		goals = append(goals, goal)
+	}
+
+	return goals, rows.Err()
+}
+
+func (s *sqliteStore) CompleteGoal(ctx context.Context, username string, id int64) error {
+	return requireAffected(s.exec(
+		ctx,
+		"CompleteGoal",
+		"UPDATE Goal SET completed_datetime = " + SQLITE_NOW + " WHERE id = ? AND username = ? AND completed_datetime IS NULL",
+		id,
+		username,
+	))
+}
+
+func (s *sqliteStore) GetGoalStats(
+	ctx context.Context,
+	username string,
+	start_date *time.Time,
+	end_date *time.Time,
+	now *time.Time,
+) (*GoalStats, error) {
+	ctx, done := startDBCall(ctx, "GetGoalStats")
+	defer done()
+
+	if start_date == nil || end_date == nil || now == nil {
+		return nil, errors.New("start_date, end_date and now cannot be nil")
+	}
+
+	days_late := "julianday(date(completed_datetime)) - julianday(end_date)"
+
+	queryPeriods := func(period_expr string, from string) ([]GoalStatsPeriod, error) {
+		rows, err := s.db.QueryContext(
+			ctx,
+			constructGoalStatsQuery(period_expr, from, days_late),
+			sqliteDate(goalFailedBefore(now)),
+			username,
+			sqliteDate(*start_date),
+			sqliteDate(*end_date),
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer rows.Close()
+
+		return scanGoalStatsRows(rows)
+	}
+
+	total, err := queryPeriods("", "Goal")
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats := GoalStats{
+		Start: start_date.Format(time.DateOnly),
+		End: end_date.Format(time.DateOnly),
+		Now: now.Format(time.DateOnly),
+		Total: total[0].GoalStatsSummary,
+	}
+
+	//'weekday 0' moves to the next sunday unless already on one, so six
+	//days before it is the monday starting the week
+	stats.ByWeek, err = queryPeriods("date(end_date, 'weekday 0', '-6 days')", "Goal")
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats.ByMonth, err = queryPeriods("date(end_date, 'start of month')", "Goal")
+
+	if err != nil {
+		return nil, err
+	}
+
+	by_tag, err := queryPeriods("tag", GOAL_STATS_BY_TAG_FROM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stats.ByTag = goalStatsTags(by_tag)
+
+	return &stats, nil
+}
+
+func (s *sqliteStore) InsertGoals(ctx context.Context, username string, goals *[]GoalInsert) error {
+	ctx, done := startDBCall(ctx, "InsertGoals")
+	defer done()
+
+	if goals == nil || len(*goals) == 0 {
+		return errors.New("no goals provided to construct query")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		slog.Error("error beginning transaction", "err", err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, goal := range *goals {
+		if goal.start_date == nil || goal.end_date == nil {
+			return errors.New("goal dates cannot be nil")
+		}
+
+		res, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO Goal (title, start_date, end_date, notes, username) VALUES (?, ?, ?, ?, ?)",
+			goal.title,
+			sqliteDate(*goal.start_date),
+			sqliteDate(*goal.end_date),
+			goal.notes,
+			username,
+		)
+
+		if err != nil {
+			slog.Error("error posting goals to db", "err", err.Error())
+			return err
+		}
+
+		goal_id, err := res.LastInsertId()
+
+		if err != nil {
+			return err
+		}
+
+		for _, tag := range goal.tags {
+			_, err = tx.ExecContext(ctx, "INSERT INTO GoalTag (goal_id, tag) VALUES (?, ?)", goal_id, tag)
+
+			if err != nil {
+				slog.Error("error posting goal tags to db", "err", err.Error())
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) InsertInviteCode(
+	ctx context.Context,
+	code_sha256 [32]byte,
+	created_by string,
+	expires *time.Time,
+	max_uses int,
+	note string,
+) error {
+	_, err := s.exec(
+		ctx,
+		"InsertInviteCode",
+		`INSERT INTO InviteCode (code_sha256, created_by, expires_datetime, max_uses, note)
+		VALUES (?, ?, ?, ?, ?)`,
+		code_sha256[:],
+		created_by,
+		sqliteNullTimestamp(expires),
+		max_uses,
+		note,
+	)
+
+	return err
+}
+
+func (s *sqliteStore) GetInviteCodes(ctx context.Context) ([]InviteCodeSummary, error) {
+	ctx, done := startDBCall(ctx, "GetInviteCodes")
+	defer done()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, created_by, created_datetime, expires_datetime, max_uses, use_count, revoked, note
+		FROM InviteCode ORDER BY created_datetime DESC, id DESC`,
+	)
+
+	if err != nil {
+		slog.Error("error retrieving invite codes from db", "err", err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var invites []InviteCodeSummary
+
+	for rows.Next() {
+		var invite InviteCodeSummary
+
+		err = rows.Scan(
+			&invite.Id,
+			&invite.CreatedBy,
+			&invite.Created,
+			&invite.Expires,
+			&invite.MaxUses,
+			&invite.UseCount,
+			&invite.Revoked,
+			&invite.Note,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		invites = append(invites, invite)
+	}
+
+	return invites, rows.Err()
+}
+
+func (s *sqliteStore) RevokeInviteCode(ctx context.Context, id int64) error {
+	return requireAffected(s.exec(ctx, "RevokeInviteCode", "UPDATE InviteCode SET revoked = TRUE WHERE id = ?", id))
+}
+
+func (s *sqliteStore) InsertAuditEvent(ctx context.Context, event *AuditEvent) error {
+	_, err := s.exec(
+		ctx,
+		"InsertAuditEvent",
+		`INSERT INTO AuditEvent (event_type, username, ip, user_agent, outcome, detail)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		event.EventType,
+		event.Username,
+		event.Ip,
+		event.UserAgent,
+		event.Outcome,
+		event.Detail,
+	)
+
+	return err
+}
+
+func (s *sqliteStore) GetAuditEvents(ctx context.Context, filter *AuditFilter) ([]AuditEvent, error) {
+	ctx, done := startDBCall(ctx, "GetAuditEvents")
+	defer done()
+
+	//sqlite reads $1, $2... as named parameters bound in order of first
+	//use, which constructAuditQuery already numbers them in
+	query, params := constructAuditQuery(filter)
+
+	for i, param := range params {
+		if t, ok := param.(time.Time); ok {
+			params[i] = sqliteTimestamp(t)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, params...)
+
+	if err != nil {
+		slog.Error("error retrieving audit events from db", "err", err.Error())
+		return nil, err
+	}
+
+	return scanAuditEvents(rows)
+}
+
+func (s *sqliteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqliteStore) GetSchemaVersion(ctx context.Context) (int, error) {
+	ctx, done := startDBCall(ctx, "GetSchemaVersion")
+	defer done()
+
+	var version int
+
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM SchemaMigration").Scan(&version)
+
+	if err != nil {
+		slog.Error("error retrieving schema version from db", "err", err.Error())
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func (s *sqliteStore) LatestMigrationVersion() (int, error) {
+	return latestMigrationVersion(sqlite_migration_files, SQLITE_MIGRATIONS_DIR)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//goals counted by the status getGoalStatus would give them
+type GoalStatsSummary struct {
+	Set        int `json:"set"`
+	Completed  int `json:"completed"`
+	Failed     int `json:"failed"`
+	InProgress int `json:"in_progress"`
+	//completed out of the goals no longer in progress, nil until there are any
+	CompletionRate *float64 `json:"completion_rate"`
+	//mean days from the due date to completion, negative when early. nil
+	//until a goal is completed
+	AvgDaysLate *float64 `json:"avg_days_late"`
+}
+
+//the goals due in the week or month starting on Start
+type GoalStatsPeriod struct {
+	Start string `json:"start"`
+	GoalStatsSummary
+}
+
+//the goals with Tag. a goal with several tags is counted under each and
+//one without any isn't counted here
+type GoalStatsTag struct {
+	Tag string `json:"tag"`
+	GoalStatsSummary
+}
+
+type GoalStats struct {
+	Start   string            `json:"start"`
+	End     string            `json:"end"`
+	Now     string            `json:"now"`
+	Total   GoalStatsSummary  `json:"total"`
+	ByWeek  []GoalStatsPeriod `json:"by_week"`
+	ByMonth []GoalStatsPeriod `json:"by_month"`
+	ByTag   []GoalStatsTag    `json:"by_tag"`
+}
+
+type StatsTemplate struct {
+	PageTemplate
+	Stats *GoalStats
+}
+
+func newGoalStatsSummary(set int, completed int, failed int, avg_days_late sql.NullFloat64) GoalStatsSummary {
+	summary := GoalStatsSummary{
+		Set: set,
+		Completed: completed,
+		Failed: failed,
+		InProgress: set - completed - failed,
+	}
+
+	if completed + failed != 0 {
+		rate := float64(completed) / float64(completed + failed)
+		summary.CompletionRate = &rate
+	}
+
+	if avg_days_late.Valid {
+		summary.AvgDaysLate = &avg_days_late.Float64
+	}
+
+	return summary
+}
+
+func (s GoalStatsSummary) CompletionRateText() string {
+	if s.CompletionRate == nil {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.0f%%", *s.CompletionRate * 100)
+}
+
+func (s GoalStatsSummary) AvgDaysLateText() string {
+	if s.AvgDaysLate == nil {
+		return "-"
+	}
+
+	days := *s.AvgDaysLate
+
+	if days < 0 {
+		return fmt.Sprintf("%.1f early", -days)
+	}
+
+	return fmt.Sprintf("%.1f late", days)
+}
+
+//the sql form of getGoalStatus. a goal without a completed_datetime is
+//failed when its end_date is before the returned date, otherwise it's in
+//progress
+func goalFailedBefore(now *time.Time) time.Time {
+	today := truncateToDate(*now)
+
+	//getGoalStatus fails a goal once now is after the start of its end date
+	if now.After(today) {
+		return today.AddDate(0, 0, 1)
+	}
+
+	return today
+}
+
+//the monday starting the week containing date
+func weekStart(date time.Time) time.Time {
+	date = truncateToDate(date)
+	days_since_monday := (int(date.Weekday()) + 6) % 7
+
+	return date.AddDate(0, 0, -days_since_monday)
+}
+
+func monthStart(date time.Time) time.Time {
+	date = truncateToDate(date)
+	return date.AddDate(0, 0, 1 - date.Day())
+}
+
+//GoalTag joined on so constructGoalStatsQuery can group by tag
+const GOAL_STATS_BY_TAG_FROM = "Goal JOIN GoalTag ON GoalTag.goal_id = Goal.id"
+
+//counts goals due between $3 and $4 by status, with $1 from
+//goalFailedBefore. period_expr is the start of the week or month as a
+//YYYY-MM-DD string, or the tag, to group by, or empty for a single total
+//row. from is Goal or GOAL_STATS_BY_TAG_FROM. days_late_expr is the days
+//between end_date and completed_datetime. params are numbered in the
+//order they appear, as sqlite binds them by position
+func constructGoalStatsQuery(period_expr string, from string, days_late_expr string) string {
+	period := "''"
+	group := ""
+
+	if period_expr != "" {
+		period = period_expr
+		group = " GROUP BY 1 ORDER BY 1"
+	}
+
+	return `SELECT ` + period + `,
+		COUNT(*),
+		COUNT(completed_datetime),
+		COALESCE(SUM(CASE WHEN completed_datetime IS NULL AND end_date < $1 THEN 1 ELSE 0 END), 0),
+		AVG(CASE WHEN completed_datetime IS NOT NULL THEN ` + days_late_expr + ` END)
+	FROM ` + from + ` WHERE username = $2 AND (end_date BETWEEN $3 AND $4)` + group
+}
+
+//the rows of a by tag query, which scanGoalStatsRows puts the tag in
+//Start of
+func goalStatsTags(periods []GoalStatsPeriod) []GoalStatsTag {
+	tags := make([]GoalStatsTag, len(periods))
+
+	for i, period := range periods {
+		tags[i] = GoalStatsTag{ Tag: period.Start, GoalStatsSummary: period.GoalStatsSummary }
+	}
+
+	return tags
+}
+
+func scanGoalStatsRows(rows *sql.Rows) ([]GoalStatsPeriod, error) {
+	periods := []GoalStatsPeriod{}
+
+	for rows.Next() {
+		var start string
+		var set, completed, failed int
+		var avg_days_late sql.NullFloat64
+
+		err := rows.Scan(&start, &set, &completed, &failed, &avg_days_late)
+
+		if err != nil {
+			return nil, err
+		}
+
+		periods = append(periods, GoalStatsPeriod{
+			Start: start,
+			GoalStatsSummary: newGoalStatsSummary(set, completed, failed, avg_days_late),
+		})
+	}
+
+	return periods, rows.Err()
+}
+
+//start, end and now are optional, defaulting to the current year as of today
+func parseGoalStatsParams(params url.Values) (start *time.Time, end *time.Time, now *time.Time, err error) {
+	parseDate := func(name string, fallback time.Time) (*time.Time, error) {
+		value := params.Get(name)
+
+		if value == "" {
+			return &fallback, nil
+		}
+
+		date, err := time.Parse(time.DateOnly, value)
+
+		if err != nil {
+			return nil, errors.New("Malformed " + name + " param")
+		}
+
+		return &date, nil
+	}
+
+	now, err = parseDate("now", truncateToDate(time.Now()))
+
+	if err != nil {
+		return
+	}
+
+	year_start := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	start, err = parseDate("start", year_start)
+
+	if err != nil {
+		return
+	}
+
+	end, err = parseDate("end", year_start.AddDate(1, 0, -1))
+
+	if err != nil {
+		return
+	}
+
+	if end.Before(*start) {
+		err = errors.New("end param must not be before start param")
+	}
+
+	return
+}
+
+//the html page, or the stats as json with format=json
+func handleStatsGet(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.Context().Value("username").(string)
+		start, end, now, err := parseGoalStatsParams(r.URL.Query())
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		stats, err := store.GetGoalStats(r.Context(), username, start, end, now)
+
+		if err != nil {
+			requestLogger(r).Error(
+				"error retrieving goal stats",
+				"username", username,
+				"err", err.Error(),
+				"response_code", http.StatusInternalServerError,
+			)
+
+			http.Error(w, "error retrieving goal stats", http.StatusInternalServerError)
+			return
+		}
+
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(stats)
+			return
+		}
+
+		is_admin, _ := r.Context().Value("is_admin").(bool)
+
+		writeTemplate(w, r, "stats.html", StatsTemplate{
+			PageTemplate: PageTemplate{
+				Username: username,
+				IsAdmin: is_admin,
+				CsrfToken: csrfTokenFromContext(r.Context()),
+			},
+			Stats: stats,
+		})
+	}
+}
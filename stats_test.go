@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+//the sql backends count failed goals with goalFailedBefore, so it has to
+//give the same status as getGoalStatus around the end date
+func TestGoalFailedBeforeMatchesGetGoalStatus(t *testing.T) {
+	end_date := "2024-02-01"
+	due, _ := time.Parse(time.DateOnly, end_date)
+
+	nows := []time.Time{
+		due.Add(-time.Nanosecond),
+		due,
+		due.Add(time.Nanosecond),
+		due.Add(12 * time.Hour),
+		due.AddDate(0, 0, 1),
+		time.Date(2024, 2, 1, 1, 0, 0, 0, time.FixedZone("UTC+2", 2 * 60 * 60)),
+	}
+
+	for _, now := range nows {
+		status, _ := getGoalStatus(Goal{ end_date: end_date }, &now)
+		failed := due.Before(goalFailedBefore(&now))
+
+		if failed != (status == "Failed") {
+			t.Errorf("now %s: getGoalStatus gives %s but goalFailedBefore gives failed %t", now, status, failed)
+		}
+	}
+}
+
+func TestWeekAndMonthStart(t *testing.T) {
+	cases := []struct {
+		date string
+		week string
+		month string
+	}{
+		{ "2024-01-01", "2024-01-01", "2024-01-01" },
+		{ "2024-01-07", "2024-01-01", "2024-01-01" },
+		{ "2024-02-29", "2024-02-26", "2024-02-01" },
+		{ "2024-12-31", "2024-12-30", "2024-12-01" },
+	}
+
+	for _, c := range cases {
+		date, _ := time.Parse(time.DateOnly, c.date)
+
+		if week := weekStart(date).Format(time.DateOnly); week != c.week {
+			t.Errorf("%s: expected week starting %s. got: %s", c.date, c.week, week)
+		}
+
+		if month := monthStart(date).Format(time.DateOnly); month != c.month {
+			t.Errorf("%s: expected month starting %s. got: %s", c.date, c.month, month)
+		}
+	}
+}
+
+func TestParseGoalStatsParams(t *testing.T) {
+	start, end, now, err := parseGoalStatsParams(url.Values{ "now": { "2024-05-06" } })
+
+	if err != nil || start.Format(time.DateOnly) != "2024-01-01" || end.Format(time.DateOnly) != "2024-12-31" ||
+	now.Format(time.DateOnly) != "2024-05-06" {
+		t.Errorf("expected the period to default to now's year. got: %s %s %s %v", start, end, now, err)
+	}
+
+	invalid := []url.Values{
+		{ "start": { "01/01/2024" } },
+		{ "now": { "today" } },
+		{ "start": { "2024-02-01" }, "end": { "2024-01-01" } },
+	}
+
+	for _, params := range invalid {
+		if _, _, _, err := parseGoalStatsParams(params); err == nil {
+			t.Errorf("expected an error for %v", params)
+		}
+	}
+}
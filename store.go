@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const PG_UNIQUE_VIOLATION = "23505"
+
+var ErrUsernameTaken = errors.New("username already exists")
+
+//everything the handlers read and write. lookups of a user, session,
+//identity or invite that doesn't exist return sql.ErrNoRows whatever the
+//backend, so handlers can keep checking for it
+type Store interface {
+	//users. InsertUser returns ErrInvalidInviteCode or ErrUsernameTaken
+	//when the user can't be created for those reasons
+	InsertUser(ctx context.Context, user *User, invite_code string) error
+	GetUser(ctx context.Context, username string) (*User, error)
+	UpdateUserPassword(ctx context.Context, username string, password_params string) error
+	UpdateUserLastLogin(ctx context.Context, username string) error
+	GetUserSummaries(ctx context.Context) ([]UserSummary, error)
+	SetUserDisabled(ctx context.Context, username string, disabled bool) error
+	DeleteUser(ctx context.Context, username string) error
+	PromoteAdmins(ctx context.Context, usernames []string) error
+	GetAccountExport(ctx context.Context, username string) (*AccountExport, error)
+
+	//oidc identities
+	GetUserIdentity(ctx context.Context, issuer string, subject string) (string, error)
+	InsertUserIdentity(ctx context.Context, identity *OidcIdentity) error
+	InsertOidcUser(ctx context.Context, identity *OidcIdentity) error
+
+	//sessions
+	UpsertSessionId(ctx context.Context, username string, session_id_sha256 [32]byte) error
+	GetSessionId(ctx context.Context, session_id_sha256 [32]byte) (username string, is_admin bool, err error)
+	DeleteSessionId(ctx context.Context, session_id_sha256 [32]byte) (string, error)
+	DeleteUserSessions(ctx context.Context, username string) error
+
+	//goals
+	GetGoals(ctx context.Context, username string, start_date *time.Time, end_date *time.Time) ([]Goal, error)
+	InsertGoals(ctx context.Context, username string, goals *[]GoalInsert) error
+	//sql.ErrNoRows when the user has no such goal or it's already complete
+	CompleteGoal(ctx context.Context, username string, id int64) error
+	//counts of the goals due between start_date and end_date by their
+	//status at now, aggregated by the backend rather than loading each goal
+	GetGoalStats(ctx context.Context, username string, start_date *time.Time, end_date *time.Time, now *time.Time) (*GoalStats, error)
+
+	//invite codes
+	InsertInviteCode(
+		ctx context.Context,
+		code_sha256 [32]byte,
+		created_by string,
+		expires *time.Time,
+		max_uses int,
+		note string,
+	) error
+	GetInviteCodes(ctx context.Context) ([]InviteCodeSummary, error)
+	RevokeInviteCode(ctx context.Context, id int64) error
+
+	//audit log
+	InsertAuditEvent(ctx context.Context, event *AuditEvent) error
+	GetAuditEvents(ctx context.Context, filter *AuditFilter) ([]AuditEvent, error)
+
+	//health. the schema is up to date when GetSchemaVersion has
+	//reached LatestMigrationVersion
+	Ping(ctx context.Context) error
+	GetSchemaVersion(ctx context.Context) (int, error)
+	LatestMigrationVersion() (int, error)
+}
+
+//the Store backed by the functions in sql.go
+type postgresStore struct {
+	db *sql.DB
+}
+
+func newPostgresStore(db *sql.DB) *postgresStore {
+	return &postgresStore{ db: db }
+}
+
+func (s *postgresStore) InsertUser(ctx context.Context, user *User, invite_code string) error {
+	pg_err := InsertUser(ctx, s.db, user, invite_code)
+
+	if pg_err == nil {
+		return nil
+	}
+
+	if pg_err.pg_err != nil && pg_err.pg_err.Code == PG_UNIQUE_VIOLATION {
+		return ErrUsernameTaken
+	}
+
+	return pg_err.err
+}
+
+func (s *postgresStore) GetUser(ctx context.Context, username string) (*User, error) {
+	return GetUser(ctx, s.db, username)
+}
+
+func (s *postgresStore) UpdateUserPassword(ctx context.Context, username string, password_params string) error {
+	return UpdateUserPassword(ctx, s.db, username, password_params)
+}
+
+func (s *postgresStore) UpdateUserLastLogin(ctx context.Context, username string) error {
+	return UpdateUserLastLogin(ctx, s.db, username)
+}
+
+func (s *postgresStore) GetUserSummaries(ctx context.Context) ([]UserSummary, error) {
+	return GetUserSummaries(ctx, s.db)
+}
+
+func (s *postgresStore) SetUserDisabled(ctx context.Context, username string, disabled bool) error {
+	return SetUserDisabled(ctx, s.db, username, disabled)
+}
+
+func (s *postgresStore) DeleteUser(ctx context.Context, username string) error {
+	return DeleteUser(ctx, s.db, username)
+}
+
+func (s *postgresStore) PromoteAdmins(ctx context.Context, usernames []string) error {
+	return PromoteAdmins(ctx, s.db, usernames)
+}
+
+func (s *postgresStore) GetAccountExport(ctx context.Context, username string) (*AccountExport, error) {
+	return GetAccountExport(ctx, s.db, username)
+}
+
+func (s *postgresStore) GetUserIdentity(ctx context.Context, issuer string, subject string) (string, error) {
+	return GetUserIdentity(ctx, s.db, issuer, subject)
+}
+
+func (s *postgresStore) InsertUserIdentity(ctx context.Context, identity *OidcIdentity) error {
+	return InsertUserIdentity(ctx, s.db, identity)
+}
+
+func (s *postgresStore) InsertOidcUser(ctx context.Context, identity *OidcIdentity) error {
+	return InsertOidcUser(ctx, s.db, identity)
+}
+
+func (s *postgresStore) UpsertSessionId(ctx context.Context, username string, session_id_sha256 [32]byte) error {
+	return UpsertSessionId(ctx, s.db, username, session_id_sha256)
+}
+
+func (s *postgresStore) GetSessionId(ctx context.Context, session_id_sha256 [32]byte) (string, bool, error) {
+	return GetSessionId(ctx, s.db, session_id_sha256)
+}
+
+func (s *postgresStore) DeleteSessionId(ctx context.Context, session_id_sha256 [32]byte) (string, error) {
+	return DeleteSessionId(ctx, s.db, session_id_sha256)
+}
+
+func (s *postgresStore) DeleteUserSessions(ctx context.Context, username string) error {
+	return DeleteUserSessions(ctx, s.db, username)
+}
+
+func (s *postgresStore) GetGoals(
+	ctx context.Context,
+	username string,
+	start_date *time.Time,
+	end_date *time.Time,
+) ([]Goal, error) {
+	return GetGoals(ctx, s.db, username, start_date, end_date)
+}
+
+func (s *postgresStore) InsertGoals(ctx context.Context, username string, goals *[]GoalInsert) error {
+	return InsertGoals(ctx, s.db, username, goals)
+}
+
+func (s *postgresStore) CompleteGoal(ctx context.Context, username string, id int64) error {
+	return CompleteGoal(ctx, s.db, username, id)
+}
+
+func (s *postgresStore) GetGoalStats(
+	ctx context.Context,
+	username string,
+	start_date *time.Time,
+	end_date *time.Time,
+	now *time.Time,
+) (*GoalStats, error) {
+	return GetGoalStats(ctx, s.db, username, start_date, end_date, now)
+}
+
+func (s *postgresStore) InsertInviteCode(
+	ctx context.Context,
+	code_sha256 [32]byte,
+	created_by string,
+	expires *time.Time,
+	max_uses int,
+	note string,
+) error {
+	return InsertInviteCode(ctx, s.db, code_sha256, created_by, expires, max_uses, note)
+}
+
+func (s *postgresStore) GetInviteCodes(ctx context.Context) ([]InviteCodeSummary, error) {
+	return GetInviteCodes(ctx, s.db)
+}
+
+func (s *postgresStore) RevokeInviteCode(ctx context.Context, id int64) error {
+	return RevokeInviteCode(ctx, s.db, id)
+}
+
+func (s *postgresStore) InsertAuditEvent(ctx context.Context, event *AuditEvent) error {
+	return InsertAuditEvent(ctx, s.db, event)
+}
+
+func (s *postgresStore) GetAuditEvents(ctx context.Context, filter *AuditFilter) ([]AuditEvent, error) {
+	return GetAuditEvents(ctx, s.db, filter)
+}
+
+func (s *postgresStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *postgresStore) GetSchemaVersion(ctx context.Context) (int, error) {
+	return GetSchemaVersion(ctx, s.db)
+}
+
+func (s *postgresStore) LatestMigrationVersion() (int, error) {
+	return latestMigrationVersion(migration_files, MIGRATIONS_DIR)
+}
@@ -0,0 +1,765 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryUser struct {
+	user User
+	last_login *time.Time
+}
+
+type memoryIdentity struct {
+	issuer string
+	subject string
+	username string
+	created time.Time
+}
+
+type memoryGoal struct {
+	id int64
+	username string
+	title string
+	start_date time.Time
+	end_date time.Time
+	completed_datetime *time.Time
+	notes string
+	tags []string
+}
+
+type memoryInvite struct {
+	summary InviteCodeSummary
+	code_sha256 [32]byte
+}
+
+//a Store kept in maps, for tests and trying the app out without postgres.
+//follows the same rules as the schema, eg. one session per user and
+//deleting a user anonymising their audit events. safe for concurrent use
+type memoryStore struct {
+	mu sync.Mutex
+	next_id int64
+	users map[string]*memoryUser
+	//keyed by username, as with the unique constraint on SessionId
+	sessions map[string][32]byte
+	identities []memoryIdentity
+	goals []memoryGoal
+	invites []*memoryInvite
+	audit_events []AuditEvent
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users: map[string]*memoryUser{},
+		sessions: map[string][32]byte{},
+	}
+}
+
+//callers must hold mu
+func (s *memoryStore) nextId() int64 {
+	s.next_id++
+	return s.next_id
+}
+
+//DATE columns only keep the day
+func truncateToDate(t time.Time) time.Time {
+	year, month, day := t.UTC().Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func (s *memoryStore) InsertUser(ctx context.Context, user *User, invite_code string) error {
+	password_params := hashPassword(user.password)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var invite *memoryInvite
+
+	if invite_code != "" {
+		hash := sha256.Sum256([]byte(invite_code))
+
+		for _, candidate := range s.invites {
+			summary := candidate.summary
+
+			if candidate.code_sha256 != hash || summary.Revoked || summary.UseCount >= summary.MaxUses {
+				continue
+			}
+
+			if summary.Expires != nil && !summary.Expires.After(time.Now()) {
+				continue
+			}
+
+			invite = candidate
+		}
+
+		if invite == nil {
+			return ErrInvalidInviteCode
+		}
+	}
+
+	if _, exists := s.users[user.username]; exists {
+		return ErrUsernameTaken
+	}
+
+	//only used up once the user is sure to be created
+	if invite != nil {
+		invite.summary.UseCount++
+	}
+
+	s.users[user.username] = &memoryUser{
+		user: User{
+			username: user.username,
+			password: password_params,
+			email: user.email,
+		},
+	}
+
+	return nil
+}
+
+func (s *memoryStore) GetUser(ctx context.Context, username string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.users[username]
+
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	//GetUser doesn't read the email from postgres either
+	user := stored.user
+	user.email = ""
+
+	return &user, nil
+}
+
+func (s *memoryStore) UpdateUserPassword(ctx context.Context, username string, password_params string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, exists := s.users[username]; exists {
+		stored.user.password = password_params
+	}
+
+	return nil
+}
+
+func (s *memoryStore) UpdateUserLastLogin(ctx context.Context, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, exists := s.users[username]; exists {
+		now := time.Now()
+		stored.last_login = &now
+	}
+
+	return nil
+}
+
+func (s *memoryStore) GetUserSummaries(ctx context.Context) ([]UserSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var users []UserSummary
+
+	for _, stored := range s.users {
+		summary := UserSummary{
+			Username: stored.user.username,
+			IsAdmin: stored.user.is_admin,
+			Disabled: stored.user.disabled,
+			LastLogin: stored.last_login,
+		}
+
+		for _, goal := range s.goals {
+			if goal.username == summary.Username {
+				summary.GoalCount++
+			}
+		}
+
+		users = append(users, summary)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	return users, nil
+}
+
+func (s *memoryStore) SetUserDisabled(ctx context.Context, username string, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.users[username]
+
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	stored.user.disabled = disabled
+
+	if disabled {
+		delete(s.sessions, username)
+	}
+
+	return nil
+}
+
+func (s *memoryStore) DeleteUser(ctx context.Context, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[username]; !exists {
+		return sql.ErrNoRows
+	}
+
+	for _, invite := range s.invites {
+		if invite.summary.CreatedBy == username {
+			invite.summary.CreatedBy = DELETED_USERNAME
+		}
+	}
+
+	for i := range s.audit_events {
+		if s.audit_events[i].Username == username {
+			s.audit_events[i].Username = DELETED_USERNAME
+			s.audit_events[i].Ip = ""
+			s.audit_events[i].UserAgent = ""
+		}
+	}
+
+	delete(s.users, username)
+	delete(s.sessions, username)
+
+	s.identities = slices.DeleteFunc(s.identities, func(identity memoryIdentity) bool {
+		return identity.username == username
+	})
+
+	s.goals = slices.DeleteFunc(s.goals, func(goal memoryGoal) bool {
+		return goal.username == username
+	})
+
+	return nil
+}
+
+func (s *memoryStore) PromoteAdmins(ctx context.Context, usernames []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, username := range usernames {
+		if stored, exists := s.users[username]; exists {
+			stored.user.is_admin = true
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) GetAccountExport(ctx context.Context, username string) (*AccountExport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.users[username]
+
+	if !exists {
+		return nil, sql.ErrNoRows
+	}
+
+	export := AccountExport{
+		Exported: time.Now().UTC(),
+		Goals: []GoalExport{},
+		Sessions: []SessionExport{},
+		Identities: []IdentityExport{},
+		AuditEvents: []AuditEvent{},
+	}
+
+	export.Account.Username = stored.user.username
+	export.Account.IsAdmin = stored.user.is_admin
+	export.Account.Disabled = stored.user.disabled
+	export.Account.LastLogin = stored.last_login
+
+	if stored.user.email != "" {
+		email := stored.user.email
+		export.Account.Email = &email
+	}
+
+	for _, goal := range s.goals {
+		if goal.username != username {
+			continue
+		}
+
+		notes := goal.notes
+
+		export.Goals = append(export.Goals, GoalExport{
+			Id: goal.id,
+			Title: goal.title,
+			StartDate: goal.start_date.Format(time.DateOnly),
+			EndDate: goal.end_date.Format(time.DateOnly),
+			Completed: goal.completed_datetime,
+			Notes: &notes,
+			Tags: slices.Sorted(slices.Values(goal.tags)),
+		})
+	}
+
+	if hash, exists := s.sessions[username]; exists {
+		export.Sessions = append(export.Sessions, SessionExport{ SessionIdSha256: hex.EncodeToString(hash[:]) })
+	}
+
+	for _, identity := range s.identities {
+		if identity.username == username {
+			export.Identities = append(export.Identities, IdentityExport{
+				Issuer: identity.issuer,
+				Subject: identity.subject,
+				Created: identity.created,
+			})
+		}
+	}
+
+	for _, event := range s.audit_events {
+		if event.Username == username {
+			export.AuditEvents = append(export.AuditEvents, event)
+		}
+	}
+
+	return &export, nil
+}
+
+func (s *memoryStore) GetUserIdentity(ctx context.Context, issuer string, subject string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, identity := range s.identities {
+		if identity.issuer == issuer && identity.subject == subject {
+			return identity.username, nil
+		}
+	}
+
+	return "", sql.ErrNoRows
+}
+
+//callers must hold mu
+func (s *memoryStore) insertIdentity(identity *OidcIdentity) error {
+	if _, exists := s.users[identity.username]; !exists {
+		return errors.New("identity references a user that doesn't exist")
+	}
+
+	for _, existing := range s.identities {
+		if existing.issuer == identity.issuer && existing.subject == identity.subject {
+			return errors.New("identity is already linked")
+		}
+	}
+
+	s.identities = append(s.identities, memoryIdentity{
+		issuer: identity.issuer,
+		subject: identity.subject,
+		username: identity.username,
+		created: time.Now(),
+	})
+
+	return nil
+}
+
+func (s *memoryStore) InsertUserIdentity(ctx context.Context, identity *OidcIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.insertIdentity(identity)
+}
+
+func (s *memoryStore) InsertOidcUser(ctx context.Context, identity *OidcIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[identity.username]; exists {
+		return ErrUsernameTaken
+	}
+
+	s.users[identity.username] = &memoryUser{
+		user: User{ username: identity.username, password: NO_PASSWORD_PARAMS, email: identity.email },
+	}
+
+	err := s.insertIdentity(identity)
+
+	//rolls back the user, as the transaction does in postgres
+	if err != nil {
+		delete(s.users, identity.username)
+	}
+
+	return err
+}
+
+func (s *memoryStore) UpsertSessionId(ctx context.Context, username string, session_id_sha256 [32]byte) error {
+	if username == "" {
+		return errors.New("empty username when attempting to insert auth token")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[username]; !exists {
+		return errors.New("session references a user that doesn't exist")
+	}
+
+	s.sessions[username] = session_id_sha256
+
+	return nil
+}
+
+func (s *memoryStore) GetSessionId(ctx context.Context, session_id_sha256 [32]byte) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for username, hash := range s.sessions {
+		if hash != session_id_sha256 {
+			continue
+		}
+
+		stored := s.users[username]
+
+		if stored.user.disabled {
+			break
+		}
+
+		return username, stored.user.is_admin, nil
+	}
+
+	return "", false, sql.ErrNoRows
+}
+
+func (s *memoryStore) DeleteSessionId(ctx context.Context, session_id_sha256 [32]byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for username, hash := range s.sessions {
+		if hash == session_id_sha256 {
+			delete(s.sessions, username)
+			return username, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (s *memoryStore) DeleteUserSessions(ctx context.Context, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, username)
+
+	return nil
+}
+
+func (s *memoryStore) GetGoals(
+	ctx context.Context,
+	username string,
+	start_date *time.Time,
+	end_date *time.Time,
+) ([]Goal, error) {
+	if start_date == nil {
+		return nil, errors.New("start_date cannot be nil")
+	}
+	if end_date == nil {
+		return nil, errors.New("end_date cannot be nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var goals []Goal
+
+	for _, goal := range s.goals {
+		if goal.username != username || goal.end_date.Before(*start_date) || goal.end_date.After(*end_date) {
+			continue
+		}
+
+		goals = append(goals, Goal{
+			id: goal.id,
+			title: goal.title,
+			start_date: goal.start_date.Format(time.DateOnly),
+			end_date: goal.end_date.Format(time.DateOnly),
+			completed_datetime: goal.completed_datetime,
+			notes: goal.notes,
+		})
+	}
+
+	return goals, nil
+}
+
+func (s *memoryStore) CompleteGoal(ctx context.Context, username string, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, goal := range s.goals {
+		if goal.id == id && goal.username == username && goal.completed_datetime == nil {
+			now := time.Now()
+			s.goals[i].completed_datetime = &now
+
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) GetGoalStats(
+	ctx context.Context,
+	username string,
+	start_date *time.Time,
+	end_date *time.Time,
+	now *time.Time,
+) (*GoalStats, error) {
+	if start_date == nil || end_date == nil || now == nil {
+		return nil, errors.New("start_date, end_date and now cannot be nil")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	//running totals in the shape the sql backends return them in
+	type counts struct {
+		set, completed, failed int
+		days_late float64
+	}
+
+	var total counts
+	weeks := map[string]*counts{}
+	months := map[string]*counts{}
+	tags := map[string]*counts{}
+
+	add := func(c *counts, status string, days_late float64) {
+		c.set++
+
+		switch status {
+		case "Complete":
+			c.completed++
+			c.days_late += days_late
+		case "Failed":
+			c.failed++
+		}
+	}
+
+	for _, goal := range s.goals {
+		if goal.username != username || goal.end_date.Before(*start_date) || goal.end_date.After(*end_date) {
+			continue
+		}
+
+		status, err := getGoalStatus(Goal{
+			end_date: goal.end_date.Format(time.DateOnly),
+			completed_datetime: goal.completed_datetime,
+		}, now)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var days_late float64
+
+		if goal.completed_datetime != nil {
+			days_late = truncateToDate(*goal.completed_datetime).Sub(goal.end_date).Hours() / 24
+		}
+
+		week := weekStart(goal.end_date).Format(time.DateOnly)
+		month := monthStart(goal.end_date).Format(time.DateOnly)
+
+		if weeks[week] == nil {
+			weeks[week] = &counts{}
+		}
+		if months[month] == nil {
+			months[month] = &counts{}
+		}
+
+		add(&total, status, days_late)
+		add(weeks[week], status, days_late)
+		add(months[month], status, days_late)
+
+		for _, tag := range goal.tags {
+			if tags[tag] == nil {
+				tags[tag] = &counts{}
+			}
+
+			add(tags[tag], status, days_late)
+		}
+	}
+
+	summarise := func(c *counts) GoalStatsSummary {
+		avg_days_late := sql.NullFloat64{}
+
+		if c.completed != 0 {
+			avg_days_late = sql.NullFloat64{ Float64: c.days_late / float64(c.completed), Valid: true }
+		}
+
+		return newGoalStatsSummary(c.set, c.completed, c.failed, avg_days_late)
+	}
+
+	periods := func(by_start map[string]*counts) []GoalStatsPeriod {
+		starts := slices.Sorted(maps.Keys(by_start))
+		periods := make([]GoalStatsPeriod, len(starts))
+
+		for i, start := range starts {
+			periods[i] = GoalStatsPeriod{ Start: start, GoalStatsSummary: summarise(by_start[start]) }
+		}
+
+		return periods
+	}
+
+	return &GoalStats{
+		Start: start_date.Format(time.DateOnly),
+		End: end_date.Format(time.DateOnly),
+		Now: now.Format(time.DateOnly),
+		Total: summarise(&total),
+		ByWeek: periods(weeks),
+		ByMonth: periods(months),
+		ByTag: goalStatsTags(periods(tags)),
+	}, nil
+}
+
+func (s *memoryStore) InsertGoals(ctx context.Context, username string, goals *[]GoalInsert) error {
+	if goals == nil || len(*goals) == 0 {
+		return errors.New("no goals provided to construct query")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.users[username]; !exists {
+		return errors.New("goal references a user that doesn't exist")
+	}
+
+	for _, goal := range *goals {
+		if goal.start_date == nil || goal.end_date == nil {
+			return errors.New("goal dates cannot be nil")
+		}
+	}
+
+	for _, goal := range *goals {
+		s.goals = append(s.goals, memoryGoal{
+			id: s.nextId(),
+			username: username,
+			title: goal.title,
+			start_date: truncateToDate(*goal.start_date),
+			end_date: truncateToDate(*goal.end_date),
+			notes: goal.notes,
+			tags: slices.Clone(goal.tags),
+		})
+	}
+
+	return nil
+}
+
+func (s *memoryStore) InsertInviteCode(
+	ctx context.Context,
+	code_sha256 [32]byte,
+	created_by string,
+	expires *time.Time,
+	max_uses int,
+	note string,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.invites = append(s.invites, &memoryInvite{
+		code_sha256: code_sha256,
+		summary: InviteCodeSummary{
+			Id: s.nextId(),
+			CreatedBy: created_by,
+			Created: time.Now(),
+			Expires: expires,
+			MaxUses: max_uses,
+			Note: note,
+		},
+	})
+
+	return nil
+}
+
+func (s *memoryStore) GetInviteCodes(ctx context.Context) ([]InviteCodeSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var invites []InviteCodeSummary
+
+	//newest first
+	for i := len(s.invites) - 1; i >= 0; i-- {
+		invites = append(invites, s.invites[i].summary)
+	}
+
+	return invites, nil
+}
+
+func (s *memoryStore) RevokeInviteCode(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, invite := range s.invites {
+		if invite.summary.Id == id {
+			invite.summary.Revoked = true
+			return nil
+		}
+	}
+
+	return sql.ErrNoRows
+}
+
+func (s *memoryStore) InsertAuditEvent(ctx context.Context, event *AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *event
+	stored.Id = s.nextId()
+	stored.Created = time.Now()
+
+	s.audit_events = append(s.audit_events, stored)
+
+	return nil
+}
+
+func (s *memoryStore) GetAuditEvents(ctx context.Context, filter *AuditFilter) ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []AuditEvent{}
+
+	//newest first, as with the ORDER BY in constructAuditQuery
+	for i := len(s.audit_events) - 1; i >= 0 && len(events) < filter.limit; i-- {
+		event := s.audit_events[i]
+
+		if filter.username != "" && event.Username != filter.username {
+			continue
+		}
+		if filter.event_type != "" && event.EventType != filter.event_type {
+			continue
+		}
+		if filter.outcome != "" && event.Outcome != filter.outcome {
+			continue
+		}
+		if filter.since != nil && event.Created.Before(*filter.since) {
+			continue
+		}
+		if filter.until != nil && !event.Created.Before(*filter.until) {
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (s *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+//there's no schema to migrate, so it's always up to date
+func (s *memoryStore) GetSchemaVersion(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (s *memoryStore) LatestMigrationVersion() (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,715 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		return newMemoryStore()
+	})
+}
+
+func TestSqliteStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		return openTestSqliteStore(t)
+	})
+}
+
+func TestPostgresStoreConformance(t *testing.T) {
+	testStoreConformance(t, func(t *testing.T) Store {
+		return newPostgresStore(openTestDB(t))
+	})
+}
+
+//a migrated sqlite database in a temporary file
+func openTestSqliteStore(t *testing.T) *sqliteStore {
+	db, err := OpenSqliteDB(filepath.Join(t.TempDir(), "goal.db"))
+
+	if err != nil {
+		t.Fatalf("error opening sqlite db: %s", err.Error())
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	if err = applySqliteMigrations(context.Background(), db); err != nil {
+		t.Fatalf("error migrating sqlite db: %s", err.Error())
+	}
+
+	return newSqliteStore(db)
+}
+
+func TestApplySqliteMigrationsIsIdempotent(t *testing.T) {
+	store := openTestSqliteStore(t)
+
+	if err := applySqliteMigrations(context.Background(), store.db); err != nil {
+		t.Fatalf("error reapplying migrations: %s", err.Error())
+	}
+
+	applied, _ := store.GetSchemaVersion(context.Background())
+	latest, _ := store.LatestMigrationVersion()
+
+	if applied != latest || latest == 0 {
+		t.Errorf("expected schema at version %d. got: %d", latest, applied)
+	}
+}
+
+//usernames are unique per call so subtests sharing a store don't collide.
+//users are deleted, and so anonymised, once the test ends
+func insertTestUser(t *testing.T, store Store, base string) string {
+	suffix, _ := generateSessionId(4)
+	username := base + "_" + suffix
+
+	user := User{ username: username, password: "password1" }
+
+	if err := store.InsertUser(context.Background(), &user, ""); err != nil {
+		t.Fatalf("error inserting %s: %s", username, err.Error())
+	}
+
+	t.Cleanup(func() { store.DeleteUser(context.Background(), username) })
+
+	return username
+}
+
+//CompleteGoal always completes as of now, so a fixed completion time is
+//set on each backend directly
+func completeTestGoal(t *testing.T, store Store, username string, title string, completed time.Time) {
+	var err error
+
+	switch store := store.(type) {
+	case *memoryStore:
+		store.mu.Lock()
+
+		for i, goal := range store.goals {
+			if goal.username == username && goal.title == title {
+				store.goals[i].completed_datetime = &completed
+			}
+		}
+
+		store.mu.Unlock()
+	case *sqliteStore:
+		_, err = store.db.Exec(
+			"UPDATE Goal SET completed_datetime = ? WHERE username = ? AND title = ?",
+			sqliteTimestamp(completed),
+			username,
+			title,
+		)
+	case *postgresStore:
+		_, err = store.db.Exec(
+			"UPDATE Goal SET completed_datetime = $1 WHERE username = $2 AND title = $3",
+			completed,
+			username,
+			title,
+		)
+	default:
+		t.Fatalf("completeTestGoal doesn't support %T", store)
+	}
+
+	if err != nil {
+		t.Fatalf("error completing goal %s: %s", title, err.Error())
+	}
+}
+
+func testDate(date string) *time.Time {
+	parsed, _ := time.Parse(time.DateOnly, date)
+	return &parsed
+}
+
+//the behaviour every Store must share, run against each backend
+func testStoreConformance(t *testing.T, newStore func(t *testing.T) Store) {
+	ctx := context.Background()
+
+	t.Run("users", func(t *testing.T) {
+		store := newStore(t)
+		username := insertTestUser(t, store, "user")
+
+		duplicate := User{ username: username, password: "password2" }
+
+		if err := store.InsertUser(ctx, &duplicate, ""); !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("expected ErrUsernameTaken for a duplicate. got: %v", err)
+		}
+
+		user, err := store.GetUser(ctx, username)
+
+		if err != nil {
+			t.Fatalf("error getting user: %s", err.Error())
+		}
+
+		if match, _ := comparePasswordWithHash("password1", user.password); !match || user.is_admin || user.disabled {
+			t.Errorf("unexpected user: %+v", user)
+		}
+
+		if _, err := store.GetUser(ctx, "missing_user"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows for a missing user. got: %v", err)
+		}
+
+		store.UpdateUserPassword(ctx, username, hashPassword("password2"))
+		store.PromoteAdmins(ctx, []string{ username })
+		store.UpdateUserLastLogin(ctx, username)
+
+		user, _ = store.GetUser(ctx, username)
+
+		if match, _ := comparePasswordWithHash("password2", user.password); !match || !user.is_admin {
+			t.Errorf("expected new password and admin. got: %+v", user)
+		}
+
+		if err := store.SetUserDisabled(ctx, username, true); err != nil {
+			t.Errorf("error disabling user: %s", err.Error())
+		}
+
+		if err := store.SetUserDisabled(ctx, "missing_user", true); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows disabling a missing user. got: %v", err)
+		}
+
+		store.InsertGoals(ctx, username, &[]GoalInsert{
+			{ title: "goal", start_date: testDate("2024-01-01"), end_date: testDate("2024-01-02") },
+		})
+
+		summaries, err := store.GetUserSummaries(ctx)
+
+		if err != nil {
+			t.Fatalf("error getting user summaries: %s", err.Error())
+		}
+
+		found := false
+
+		for _, summary := range summaries {
+			if summary.Username != username {
+				continue
+			}
+
+			found = true
+
+			if !summary.IsAdmin || !summary.Disabled || summary.GoalCount != 1 || summary.LastLogin == nil {
+				t.Errorf("unexpected summary: %+v", summary)
+			} else if time.Since(*summary.LastLogin).Abs() > time.Minute {
+				t.Errorf("expected last login to be now. got: %s", summary.LastLogin)
+			}
+		}
+
+		if !found {
+			t.Error("user missing from summaries")
+		}
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		store := newStore(t)
+		username := insertTestUser(t, store, "session")
+
+		first := sha256.Sum256([]byte("first " + username))
+		second := sha256.Sum256([]byte("second " + username))
+
+		if err := store.UpsertSessionId(ctx, username, first); err != nil {
+			t.Fatalf("error inserting session: %s", err.Error())
+		}
+
+		if got, is_admin, err := store.GetSessionId(ctx, first); err != nil || got != username || is_admin {
+			t.Errorf("expected session for %s. got: %s %t %v", username, got, is_admin, err)
+		}
+
+		//one session per user, a new login replaces the old one
+		store.UpsertSessionId(ctx, username, second)
+
+		if _, _, err := store.GetSessionId(ctx, first); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected replaced session to be gone. got: %v", err)
+		}
+
+		if got, err := store.DeleteSessionId(ctx, second); err != nil || got != username {
+			t.Errorf("expected delete to return %s. got: %s %v", username, got, err)
+		}
+
+		if got, err := store.DeleteSessionId(ctx, second); err != nil || got != "" {
+			t.Errorf("expected deleting a missing session to return nothing. got: %s %v", got, err)
+		}
+
+		store.UpsertSessionId(ctx, username, first)
+		store.SetUserDisabled(ctx, username, true)
+
+		if _, _, err := store.GetSessionId(ctx, first); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected disabling to end the session. got: %v", err)
+		}
+
+		store.SetUserDisabled(ctx, username, false)
+		store.UpsertSessionId(ctx, username, first)
+		store.DeleteUserSessions(ctx, username)
+
+		if _, _, err := store.GetSessionId(ctx, first); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected DeleteUserSessions to end the session. got: %v", err)
+		}
+
+		if err := store.UpsertSessionId(ctx, "", first); err == nil {
+			t.Error("expected an error for an empty username")
+		}
+	})
+
+	t.Run("goals", func(t *testing.T) {
+		store := newStore(t)
+		username := insertTestUser(t, store, "goals")
+		other := insertTestUser(t, store, "other")
+
+		goals := []GoalInsert{
+			{ title: "january", start_date: testDate("2024-01-01"), end_date: testDate("2024-01-31"), notes: "notes" },
+			{ title: "march", start_date: testDate("2024-02-15"), end_date: testDate("2024-03-01") },
+		}
+
+		if err := store.InsertGoals(ctx, username, &goals); err != nil {
+			t.Fatalf("error inserting goals: %s", err.Error())
+		}
+
+		if err := store.InsertGoals(ctx, username, &[]GoalInsert{}); err == nil {
+			t.Error("expected an error inserting no goals")
+		}
+
+		//the range is inclusive and on the end date only
+		got, err := store.GetGoals(ctx, username, testDate("2024-01-31"), testDate("2024-02-29"))
+
+		if err != nil {
+			t.Fatalf("error getting goals: %s", err.Error())
+		}
+
+		if len(got) != 1 {
+			t.Fatalf("expected 1 goal. got: %+v", got)
+		}
+
+		goal := got[0]
+
+		if goal.title != "january" || goal.start_date != "2024-01-01" || goal.end_date != "2024-01-31" ||
+		goal.notes != "notes" || goal.completed_datetime != nil {
+			t.Errorf("unexpected goal: %+v", goal)
+		}
+
+		if status, _ := getGoalStatus(goal, testDate("2024-02-01")); status != "Failed" {
+			t.Errorf("expected goal to have failed. got: %s", status)
+		}
+
+		if got, _ := store.GetGoals(ctx, username, testDate("2024-01-01"), testDate("2024-12-31")); len(got) != 2 {
+			t.Errorf("expected 2 goals. got: %d", len(got))
+		}
+
+		if got, _ := store.GetGoals(ctx, other, testDate("2024-01-01"), testDate("2024-12-31")); len(got) != 0 {
+			t.Errorf("expected no goals for another user. got: %d", len(got))
+		}
+
+		if _, err := store.GetGoals(ctx, username, nil, testDate("2024-12-31")); err == nil {
+			t.Error("expected an error for a nil start date")
+		}
+
+		if err := store.CompleteGoal(ctx, other, goal.id); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows completing another user's goal. got: %v", err)
+		}
+
+		if err := store.CompleteGoal(ctx, username, goal.id); err != nil {
+			t.Fatalf("error completing goal: %s", err.Error())
+		}
+
+		if err := store.CompleteGoal(ctx, username, goal.id); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows completing a goal twice. got: %v", err)
+		}
+
+		got, _ = store.GetGoals(ctx, username, testDate("2024-01-31"), testDate("2024-01-31"))
+
+		if len(got) != 1 || got[0].completed_datetime == nil {
+			t.Errorf("expected the goal to be complete. got: %+v", got)
+		}
+	})
+
+	t.Run("goal stats", func(t *testing.T) {
+		store := newStore(t)
+		username := insertTestUser(t, store, "stats")
+		other := insertTestUser(t, store, "other")
+
+		goals := []GoalInsert{
+			{ title: "early", start_date: testDate("2024-01-01"), end_date: testDate("2024-01-03"), tags: []string{ "work", "health" } },
+			{ title: "failed", start_date: testDate("2024-01-01"), end_date: testDate("2024-01-07"), tags: []string{ "work" } },
+			{ title: "late", start_date: testDate("2024-01-08"), end_date: testDate("2024-01-31"), tags: []string{ "health" } },
+			{ title: "due now", start_date: testDate("2024-01-08"), end_date: testDate("2024-02-01") },
+			{ title: "outside", start_date: testDate("2024-01-08"), end_date: testDate("2024-12-31"), tags: []string{ "work" } },
+		}
+
+		if err := store.InsertGoals(ctx, username, &goals); err != nil {
+			t.Fatalf("error inserting goals: %s", err.Error())
+		}
+
+		store.InsertGoals(ctx, other, &[]GoalInsert{
+			{ title: "other", start_date: testDate("2024-01-01"), end_date: testDate("2024-01-03"), tags: []string{ "work" } },
+		})
+
+		completeTestGoal(t, store, username, "early", time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC))
+		completeTestGoal(t, store, username, "late", time.Date(2024, 2, 3, 23, 30, 0, 0, time.UTC))
+
+		stats, err := store.GetGoalStats(ctx, username, testDate("2024-01-01"), testDate("2024-06-30"), testDate("2024-02-01"))
+
+		if err != nil {
+			t.Fatalf("error getting goal stats: %s", err.Error())
+		}
+
+		total := stats.Total
+
+		if total.Set != 4 || total.Completed != 2 || total.Failed != 1 || total.InProgress != 1 {
+			t.Errorf("unexpected totals: %+v", total)
+		}
+
+		if total.CompletionRate == nil || math.Abs(*total.CompletionRate - 2.0 / 3) > 1e-9 {
+			t.Errorf("expected a completion rate of 2/3. got: %v", total.CompletionRate)
+		}
+
+		//2 days early and 3 days late
+		if total.AvgDaysLate == nil || math.Abs(*total.AvgDaysLate - 0.5) > 1e-9 {
+			t.Errorf("expected 0.5 average days late. got: %v", total.AvgDaysLate)
+		}
+
+		periods := func(periods []GoalStatsPeriod) []string {
+			summaries := make([]string, len(periods))
+
+			for i, period := range periods {
+				summaries[i] = fmt.Sprintf("%s %d/%d/%d/%d", period.Start, period.Set, period.Completed, period.Failed, period.InProgress)
+			}
+
+			return summaries
+		}
+
+		expected_weeks := []string{ "2024-01-01 2/1/1/0", "2024-01-29 2/1/0/1" }
+
+		if got := periods(stats.ByWeek); !slices.Equal(got, expected_weeks) {
+			t.Errorf("expected weeks %v. got: %v", expected_weeks, got)
+		}
+
+		expected_months := []string{ "2024-01-01 3/2/1/0", "2024-02-01 1/0/0/1" }
+
+		if got := periods(stats.ByMonth); !slices.Equal(got, expected_months) {
+			t.Errorf("expected months %v. got: %v", expected_months, got)
+		}
+
+		//untagged goals aren't counted and a goal with two tags is under both
+		expected_tags := []string{ "health 2/2/0/0", "work 2/1/1/0" }
+		tags := []string{}
+
+		for _, tag := range stats.ByTag {
+			tags = append(tags, fmt.Sprintf("%s %d/%d/%d/%d", tag.Tag, tag.Set, tag.Completed, tag.Failed, tag.InProgress))
+		}
+
+		if !slices.Equal(tags, expected_tags) {
+			t.Errorf("expected tags %v. got: %v", expected_tags, tags)
+		}
+
+		//past the start of its end date the goal due now has failed, as with getGoalStatus
+		later := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+		stats, _ = store.GetGoalStats(ctx, username, testDate("2024-01-01"), testDate("2024-06-30"), &later)
+
+		if stats.Total.Failed != 2 || stats.Total.InProgress != 0 {
+			t.Errorf("expected the goal due now to have failed by midday. got: %+v", stats.Total)
+		}
+
+		stats, err = store.GetGoalStats(ctx, username, testDate("2023-01-01"), testDate("2023-12-31"), testDate("2024-02-01"))
+
+		if err != nil || stats.Total.Set != 0 || stats.Total.CompletionRate != nil || stats.Total.AvgDaysLate != nil ||
+		len(stats.ByWeek) != 0 || len(stats.ByMonth) != 0 || len(stats.ByTag) != 0 {
+			t.Errorf("expected empty stats for a period without goals. got: %+v %v", stats, err)
+		}
+	})
+
+	t.Run("invites", func(t *testing.T) {
+		store := newStore(t)
+		admin := insertTestUser(t, store, "admin")
+
+		insertInvite := func(code string, expires *time.Time, max_uses int) {
+			err := store.InsertInviteCode(ctx, sha256.Sum256([]byte(code)), admin, expires, max_uses, "note")
+
+			if err != nil {
+				t.Fatalf("error inserting invite: %s", err.Error())
+			}
+		}
+
+		suffix, _ := generateSessionId(4)
+		past := time.Now().Add(-time.Hour)
+		future := time.Now().Add(time.Hour)
+
+		insertInvite("once " + suffix, &future, 1)
+		insertInvite("expired " + suffix, &past, 1)
+		insertInvite("revoked " + suffix, nil, 5)
+
+		register := func(base string, code string) error {
+			user := User{ username: base + "_" + suffix, password: "password1" }
+			err := store.InsertUser(ctx, &user, code)
+
+			if err == nil {
+				t.Cleanup(func() { store.DeleteUser(ctx, user.username) })
+			}
+
+			return err
+		}
+
+		invites, err := store.GetInviteCodes(ctx)
+
+		if err != nil {
+			t.Fatalf("error getting invites: %s", err.Error())
+		}
+
+		var revoked_id int64
+		var once InviteCodeSummary
+
+		for _, invite := range invites {
+			if invite.CreatedBy != admin {
+				continue
+			}
+
+			if invite.MaxUses == 5 {
+				revoked_id = invite.Id
+			} else if invite.Expires != nil && invite.Expires.After(time.Now()) {
+				once = invite
+			}
+		}
+
+		if once.Expires == nil || once.Expires.Sub(future).Abs() > time.Second || once.Note != "note" || once.Revoked {
+			t.Errorf("unexpected invite: %+v", once)
+		}
+
+		if err := store.RevokeInviteCode(ctx, revoked_id); err != nil {
+			t.Errorf("error revoking invite: %s", err.Error())
+		}
+
+		//a failed insert mustn't use the invite up
+		taken := User{ username: admin, password: "password1" }
+
+		if err := store.InsertUser(ctx, &taken, "once " + suffix); !errors.Is(err, ErrUsernameTaken) {
+			t.Errorf("expected ErrUsernameTaken registering a taken username. got: %v", err)
+		}
+
+		if err := register("invited", "once " + suffix); err != nil {
+			t.Errorf("error registering with invite: %v", err)
+		}
+
+		for _, code := range []string{ "once", "expired", "revoked", "unknown" } {
+			if err := register(code, code + " " + suffix); !errors.Is(err, ErrInvalidInviteCode) {
+				t.Errorf("expected ErrInvalidInviteCode for the %s invite. got: %v", code, err)
+			}
+		}
+
+		if err := store.RevokeInviteCode(ctx, -1); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows revoking a missing invite. got: %v", err)
+		}
+	})
+
+	t.Run("identities", func(t *testing.T) {
+		store := newStore(t)
+		suffix, _ := generateSessionId(4)
+		issuer := "https://issuer.example.com/" + suffix
+
+		identity := OidcIdentity{ issuer: issuer, subject: "1", username: "oidc_" + suffix }
+
+		if err := store.InsertOidcUser(ctx, &identity); err != nil {
+			t.Fatalf("error inserting oidc user: %s", err.Error())
+		}
+
+		t.Cleanup(func() { store.DeleteUser(ctx, identity.username) })
+
+		if err := store.InsertOidcUser(ctx, &identity); err == nil {
+			t.Error("expected an error inserting the same oidc user twice")
+		}
+
+		user, err := store.GetUser(ctx, identity.username)
+
+		if err != nil || user.password != NO_PASSWORD_PARAMS {
+			t.Errorf("expected a password-less user. got: %+v %v", user, err)
+		}
+
+		existing := insertTestUser(t, store, "linked")
+		link := OidcIdentity{ issuer: issuer, subject: "2", username: existing }
+
+		if err := store.InsertUserIdentity(ctx, &link); err != nil {
+			t.Errorf("error linking identity: %s", err.Error())
+		}
+
+		for _, expected := range []OidcIdentity{ identity, link } {
+			if got, err := store.GetUserIdentity(ctx, issuer, expected.subject); err != nil || got != expected.username {
+				t.Errorf("expected identity for %s. got: %s %v", expected.username, got, err)
+			}
+		}
+
+		if _, err := store.GetUserIdentity(ctx, issuer, "3"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows for a missing identity. got: %v", err)
+		}
+	})
+
+	t.Run("delete user", func(t *testing.T) {
+		store := newStore(t)
+		username := insertTestUser(t, store, "deleted")
+		suffix, _ := generateSessionId(4)
+		issuer := "https://issuer.example.com/" + suffix
+		session := sha256.Sum256([]byte(username))
+
+		store.InsertGoals(ctx, username, &[]GoalInsert{
+			{ title: "goal", start_date: testDate("2024-01-01"), end_date: testDate("2024-01-02") },
+		})
+		store.UpsertSessionId(ctx, username, session)
+		store.InsertUserIdentity(ctx, &OidcIdentity{ issuer: issuer, subject: "1", username: username })
+		store.InsertInviteCode(ctx, sha256.Sum256([]byte(suffix)), username, nil, 1, "")
+		store.InsertAuditEvent(ctx, &AuditEvent{
+			EventType: AUDIT_LOGIN,
+			Username: username,
+			Ip: "192.0.2.1",
+			UserAgent: "agent " + suffix,
+			Outcome: AUDIT_SUCCESS,
+		})
+
+		if err := store.DeleteUser(ctx, username); err != nil {
+			t.Fatalf("error deleting user: %s", err.Error())
+		}
+
+		if err := store.DeleteUser(ctx, username); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows deleting twice. got: %v", err)
+		}
+
+		if _, err := store.GetUser(ctx, username); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected user to be gone. got: %v", err)
+		}
+
+		if goals, _ := store.GetGoals(ctx, username, testDate("2024-01-01"), testDate("2024-01-31")); len(goals) != 0 {
+			t.Errorf("expected goals to be gone. got: %d", len(goals))
+		}
+
+		if _, _, err := store.GetSessionId(ctx, session); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected session to be gone. got: %v", err)
+		}
+
+		if _, err := store.GetUserIdentity(ctx, issuer, "1"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected identity to be gone. got: %v", err)
+		}
+
+		events, _ := store.GetAuditEvents(ctx, &AuditFilter{ username: username, limit: AUDIT_DEFAULT_LIMIT })
+
+		if len(events) != 0 {
+			t.Errorf("expected no events left under the username. got: %d", len(events))
+		}
+
+		events, _ = store.GetAuditEvents(ctx, &AuditFilter{ username: DELETED_USERNAME, limit: AUDIT_MAX_LIMIT })
+		anonymised := false
+
+		for _, event := range events {
+			if event.EventType == AUDIT_LOGIN && event.Ip == "" && event.UserAgent == "" {
+				anonymised = true
+			}
+		}
+
+		if !anonymised {
+			t.Error("expected the audit event to be anonymised")
+		}
+	})
+
+	t.Run("audit events", func(t *testing.T) {
+		store := newStore(t)
+		username := insertTestUser(t, store, "audited")
+		before := time.Now().Add(-time.Minute)
+
+		for _, outcome := range []string{ AUDIT_FAILURE, AUDIT_SUCCESS, AUDIT_SUCCESS } {
+			err := store.InsertAuditEvent(ctx, &AuditEvent{
+				EventType: AUDIT_LOGIN,
+				Username: username,
+				Ip: "192.0.2.1",
+				UserAgent: "agent",
+				Outcome: outcome,
+				Detail: "detail",
+			})
+
+			if err != nil {
+				t.Fatalf("error inserting audit event: %s", err.Error())
+			}
+		}
+
+		store.InsertAuditEvent(ctx, &AuditEvent{ EventType: AUDIT_LOGOUT, Username: username, Outcome: AUDIT_SUCCESS })
+
+		events, err := store.GetAuditEvents(ctx, &AuditFilter{ username: username, limit: AUDIT_DEFAULT_LIMIT })
+
+		if err != nil {
+			t.Fatalf("error getting audit events: %s", err.Error())
+		}
+
+		if len(events) != 4 || events[0].EventType != AUDIT_LOGOUT || events[3].Outcome != AUDIT_FAILURE {
+			t.Fatalf("expected 4 events newest first. got: %+v", events)
+		}
+
+		if events[3].Ip != "192.0.2.1" || events[3].Detail != "detail" || events[3].Created.Before(before) {
+			t.Errorf("unexpected event: %+v", events[3])
+		}
+
+		filters := map[string]AuditFilter{
+			"event type": { username: username, event_type: AUDIT_LOGIN, limit: AUDIT_DEFAULT_LIMIT },
+			"outcome": { username: username, outcome: AUDIT_SUCCESS, limit: AUDIT_DEFAULT_LIMIT },
+			"limit": { username: username, limit: 3 },
+			"since": { username: username, since: &before, limit: 3 },
+		}
+
+		for name, filter := range filters {
+			if events, _ := store.GetAuditEvents(ctx, &filter); len(events) != 3 {
+				t.Errorf("expected 3 events filtering by %s. got: %d", name, len(events))
+			}
+		}
+
+		if events, _ := store.GetAuditEvents(ctx, &AuditFilter{ username: username, until: &before, limit: 1 }); len(events) != 0 {
+			t.Errorf("expected no events before the test started. got: %d", len(events))
+		}
+	})
+
+	t.Run("account export", func(t *testing.T) {
+		store := newStore(t)
+		username := insertTestUser(t, store, "exported")
+
+		store.InsertGoals(ctx, username, &[]GoalInsert{
+			{ title: "goal", start_date: testDate("2024-01-01"), end_date: testDate("2024-01-02"), notes: "notes", tags: []string{ "work", "health" } },
+		})
+		store.UpsertSessionId(ctx, username, sha256.Sum256([]byte(username)))
+		store.InsertAuditEvent(ctx, &AuditEvent{ EventType: AUDIT_LOGIN, Username: username, Outcome: AUDIT_SUCCESS })
+
+		export, err := store.GetAccountExport(ctx, username)
+
+		if err != nil {
+			t.Fatalf("error exporting account: %s", err.Error())
+		}
+
+		if export.Account.Username != username || export.Account.Email != nil || len(export.Sessions) != 1 || len(export.AuditEvents) != 1 {
+			t.Errorf("unexpected export: %+v", export)
+		}
+
+		if len(export.Goals) != 1 {
+			t.Fatalf("expected 1 goal. got: %+v", export.Goals)
+		}
+
+		goal := export.Goals[0]
+
+		if goal.StartDate != "2024-01-01" || goal.EndDate != "2024-01-02" || goal.Notes == nil || *goal.Notes != "notes" ||
+		!slices.Equal(goal.Tags, []string{ "health", "work" }) {
+			t.Errorf("unexpected exported goal: %+v", goal)
+		}
+
+		if _, err := store.GetAccountExport(ctx, "missing_user"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows exporting a missing user. got: %v", err)
+		}
+	})
+
+	t.Run("health", func(t *testing.T) {
+		store := newStore(t)
+
+		if err := store.Ping(ctx); err != nil {
+			t.Errorf("error pinging store: %s", err.Error())
+		}
+
+		applied, err := store.GetSchemaVersion(ctx)
+
+		if err != nil {
+			t.Fatalf("error getting schema version: %s", err.Error())
+		}
+
+		if latest, _ := store.LatestMigrationVersion(); applied < latest {
+			t.Errorf("expected schema at version %d. got: %d", latest, applied)
+		}
+	})
+}
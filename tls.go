@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const DEFAULT_TLS_MIN_VERSION = "1.2"
+
+type TlsConfig struct {
+	//setting either enables tls, both are then required
+	Cert_file     string `json:"cert_file"`
+	Key_file      string `json:"key_file"`
+	//1.2 or 1.3, defaults to 1.2
+	Min_version   string `json:"min_version"`
+	//optional plain http port that redirects every request to https
+	Redirect_port uint16 `json:"redirect_port"`
+}
+
+func tlsEnabled(conf *TlsConfig) bool {
+	return conf.Cert_file != "" || conf.Key_file != ""
+}
+
+func parseTlsVersion(version string) (uint16, error) {
+	switch version {
+	case "", DEFAULT_TLS_MIN_VERSION:
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("%s is not one of 1.2, 1.3", version)
+	}
+}
+
+func validateTlsConfig(conf *TlsConfig) []error {
+	violations := []error{}
+
+	if !tlsEnabled(conf) {
+		if conf.Redirect_port != 0 {
+			violations = append(violations, schemaViolation{
+				pointer: "/tls/redirect_port",
+				message: "requires cert_file and key_file",
+			})
+		}
+
+		return violations
+	}
+
+	if conf.Cert_file == "" {
+		violations = append(violations, schemaViolation{ pointer: "/tls/cert_file", message: "required with key_file" })
+	}
+
+	if conf.Key_file == "" {
+		violations = append(violations, schemaViolation{ pointer: "/tls/key_file", message: "required with cert_file" })
+	}
+
+	if _, err := parseTlsVersion(conf.Min_version); err != nil {
+		violations = append(violations, schemaViolation{ pointer: "/tls/min_version", message: err.Error() })
+	}
+
+	return violations
+}
+
+//serves the certificate from disk, loading it again whenever the
+//cert or key file changes so renewed certificates need no restart
+type certReloader struct {
+	cert_file string
+	key_file string
+
+	mu sync.Mutex
+	cert *tls.Certificate
+	cert_mod_time time.Time
+	key_mod_time time.Time
+}
+
+func newCertReloader(cert_file string, key_file string) (*certReloader, error) {
+	reloader := certReloader{ cert_file: cert_file, key_file: key_file }
+	_, err := reloader.certificate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &reloader, nil
+}
+
+func (c *certReloader) certificate() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cert_info, err := os.Stat(c.cert_file)
+
+	if err != nil {
+		return c.fallback(err)
+	}
+
+	key_info, err := os.Stat(c.key_file)
+
+	if err != nil {
+		return c.fallback(err)
+	}
+
+	if c.cert != nil && cert_info.ModTime().Equal(c.cert_mod_time) && key_info.ModTime().Equal(c.key_mod_time) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.cert_file, c.key_file)
+
+	if err != nil {
+		return c.fallback(err)
+	}
+
+	if c.cert != nil {
+		slog.Info("tls certificate reloaded", "cert_file", c.cert_file)
+	}
+
+	c.cert = &cert
+	c.cert_mod_time = cert_info.ModTime()
+	c.key_mod_time = key_info.ModTime()
+
+	return c.cert, nil
+}
+
+//keeps serving the last good certificate if a reload fails, eg. while
+//the cert has been written but the matching key hasn't yet
+func (c *certReloader) fallback(err error) (*tls.Certificate, error) {
+	if c.cert == nil {
+		slog.Error("error loading tls certificate", "err", err.Error())
+		return nil, err
+	}
+
+	slog.Warn("error reloading tls certificate, serving the previous one", "err", err.Error())
+
+	return c.cert, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.certificate()
+}
+
+func initialiseTlsConfig(conf *TlsConfig) (*tls.Config, error) {
+	min_version, err := parseTlsVersion(conf.Min_version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reloader, err := newCertReloader(conf.Cert_file, conf.Key_file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: min_version,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+//redirects to the same host and path on the https port
+func handleHttpsRedirect(https_port uint16) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+
+		if err != nil {
+			host = r.Host
+		}
+
+		if https_port != 443 {
+			host = net.JoinHostPort(host, fmt.Sprint(https_port))
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	}
+}
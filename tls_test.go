@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//writes a self signed certificate for localhost and returns the cert and key paths
+func writeTestCertificate(t *testing.T, dir string, common_name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{ CommonName: common_name },
+		DNSNames: []string{ "localhost" },
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{ x509.ExtKeyUsageServerAuth },
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatalf("error creating certificate: %s", err.Error())
+	}
+
+	key_der, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatalf("error marshalling key: %s", err.Error())
+	}
+
+	cert_path := filepath.Join(dir, "cert.pem")
+	key_path := filepath.Join(dir, "key.pem")
+
+	err = os.WriteFile(cert_path, pem.EncodeToMemory(&pem.Block{ Type: "CERTIFICATE", Bytes: der }), 0600)
+
+	if err != nil {
+		t.Fatalf("error writing certificate: %s", err.Error())
+	}
+
+	err = os.WriteFile(key_path, pem.EncodeToMemory(&pem.Block{ Type: "EC PRIVATE KEY", Bytes: key_der }), 0600)
+
+	if err != nil {
+		t.Fatalf("error writing key: %s", err.Error())
+	}
+
+	return cert_path, key_path
+}
+
+func servedCommonName(t *testing.T, reloader *certReloader) string {
+	cert, err := reloader.GetCertificate(nil)
+
+	if err != nil {
+		t.Fatalf("error getting certificate: %s", err.Error())
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+
+	if err != nil {
+		t.Fatalf("error parsing certificate: %s", err.Error())
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	cert_path, key_path := writeTestCertificate(t, dir, "first")
+
+	reloader, err := newCertReloader(cert_path, key_path)
+
+	if err != nil {
+		t.Fatalf("error loading certificate: %s", err.Error())
+	}
+
+	if name := servedCommonName(t, reloader); name != "first" {
+		t.Errorf("expected first certificate, got %s", name)
+	}
+
+	writeTestCertificate(t, dir, "second")
+
+	//some filesystems have coarse mod times, make sure the change is visible
+	later := time.Now().Add(time.Second)
+	os.Chtimes(cert_path, later, later)
+	os.Chtimes(key_path, later, later)
+
+	if name := servedCommonName(t, reloader); name != "second" {
+		t.Errorf("expected reloaded certificate, got %s", name)
+	}
+
+	//a broken key keeps the previous certificate in service
+	os.WriteFile(key_path, []byte("not a key"), 0600)
+	even_later := later.Add(time.Second)
+	os.Chtimes(key_path, even_later, even_later)
+
+	if name := servedCommonName(t, reloader); name != "second" {
+		t.Errorf("expected previous certificate after failed reload, got %s", name)
+	}
+
+	_, err = newCertReloader(filepath.Join(dir, "missing.pem"), key_path)
+
+	if err == nil {
+		t.Error("loading a missing certificate should fail")
+	}
+}
+
+func TestTlsServer(t *testing.T) {
+	cert_path, key_path := writeTestCertificate(t, t.TempDir(), "server")
+
+	tls_config, err := initialiseTlsConfig(&TlsConfig{ Cert_file: cert_path, Key_file: key_path, Min_version: "1.3" })
+
+	if err != nil {
+		t.Fatalf("error creating tls config: %s", err.Error())
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(handlePing))
+	server.TLS = tls_config
+	server.StartTLS()
+	defer server.Close()
+
+	cert_pem, _ := os.ReadFile(cert_path)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(cert_pem)
+
+	client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ RootCAs: roots, ServerName: "localhost", MaxVersion: tls.VersionTLS13 },
+		},
+	}
+
+	res, err := client.Get(server.URL + "/ping")
+
+	if err != nil {
+		t.Fatalf("https request failed: %s", err.Error())
+	}
+
+	res.Body.Close()
+
+	if res.TLS == nil || res.TLS.Version != tls.VersionTLS13 {
+		t.Error("expected a tls 1.3 connection")
+	}
+
+	old_client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ RootCAs: roots, ServerName: "localhost", MaxVersion: tls.VersionTLS12 },
+		},
+	}
+
+	_, err = old_client.Get(server.URL + "/ping")
+
+	if err == nil {
+		t.Error("tls 1.2 client should be rejected when min_version is 1.3")
+	}
+}
+
+func TestHttpsRedirect(t *testing.T) {
+	tests := []struct {
+		port uint16
+		host string
+		target string
+		expected string
+	}{
+		{ 443, "example.com", "/goals?week=1", "https://example.com/goals?week=1" },
+		{ 443, "example.com:80", "/", "https://example.com/" },
+		{ 8443, "example.com:8080", "/login", "https://example.com:8443/login" },
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.target, nil)
+		req.Host = test.host
+		res := httptest.NewRecorder()
+
+		handleHttpsRedirect(test.port)(res, req)
+
+		if res.Code != http.StatusMovedPermanently {
+			t.Errorf("expected %d, got %d", http.StatusMovedPermanently, res.Code)
+		}
+
+		if location := res.Header().Get("Location"); location != test.expected {
+			t.Errorf("expected redirect to %s, got %s", test.expected, location)
+		}
+	}
+}
+
+func TestValidateTlsConfig(t *testing.T) {
+	valid := []TlsConfig{
+		{},
+		{ Cert_file: "cert.pem", Key_file: "key.pem" },
+		{ Cert_file: "cert.pem", Key_file: "key.pem", Min_version: "1.3", Redirect_port: 80 },
+	}
+
+	for _, conf := range valid {
+		if violations := validateTlsConfig(&conf); len(violations) != 0 {
+			t.Errorf("expected %+v to be valid, got %v", conf, violations)
+		}
+	}
+
+	invalid := []TlsConfig{
+		{ Redirect_port: 80 },
+		{ Cert_file: "cert.pem" },
+		{ Key_file: "key.pem" },
+		{ Cert_file: "cert.pem", Key_file: "key.pem", Min_version: "1.1" },
+	}
+
+	for _, conf := range invalid {
+		if violations := validateTlsConfig(&conf); len(violations) == 0 {
+			t.Errorf("expected %+v to be invalid", conf)
+		}
+	}
+
+	conf := Config{ Host: "localhost", Port: 443 }
+	conf.Db.Host, conf.Db.Port, conf.Db.Database_name, conf.Db.Username, conf.Db.Password = "localhost", 5432, "goal", "goal", "goal"
+	conf.Tls = TlsConfig{ Cert_file: "cert.pem", Key_file: "key.pem" }
+	conf.Session.Insecure_cookies = true
+
+	if validateConfig(&conf) == nil {
+		t.Error("insecure cookies should be rejected when tls is enabled")
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const TRACING_NONE = "none"
+const TRACING_OTLP = "otlp"
+const TRACING_STDOUT = "stdout"
+
+const TRACER_NAME = "goal"
+const DEFAULT_SERVICE_NAME = "goal-tracker"
+
+type TracingConfig struct {
+	//none, otlp or stdout. defaults to none
+	Exporter     string  `json:"exporter"`
+	//otlp http collector address, eg. localhost:4318. when empty the
+	//standard OTEL_EXPORTER_OTLP_* environment variables are used
+	Endpoint     string  `json:"endpoint"`
+	//sends to the collector over plain http
+	Insecure     bool    `json:"insecure"`
+	//fraction of new traces sampled, 0 is treated as 1
+	Sample_ratio float64 `json:"sample_ratio"`
+	Service_name string  `json:"service_name"`
+}
+
+//spans started before initialiseTracing, or with tracing disabled, are no-ops
+var tracer = otel.Tracer(TRACER_NAME)
+
+func validateTracingConfig(conf *TracingConfig) []error {
+	violations := []error{}
+
+	switch conf.Exporter {
+	case "", TRACING_NONE, TRACING_OTLP, TRACING_STDOUT:
+	default:
+		violations = append(violations, schemaViolation{
+			pointer: "/tracing/exporter",
+			message: conf.Exporter + " is not one of none, otlp, stdout",
+		})
+	}
+
+	if conf.Sample_ratio < 0 || conf.Sample_ratio > 1 {
+		violations = append(violations, schemaViolation{
+			pointer: "/tracing/sample_ratio",
+			message: "must be between 0 and 1",
+		})
+	}
+
+	return violations
+}
+
+func newSpanExporter(ctx context.Context, conf *TracingConfig) (sdktrace.SpanExporter, error) {
+	switch conf.Exporter {
+	case TRACING_STDOUT:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case TRACING_OTLP:
+		options := []otlptracehttp.Option{}
+
+		if conf.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(conf.Endpoint))
+		}
+
+		if conf.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+
+		return otlptracehttp.New(ctx, options...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %s", conf.Exporter)
+	}
+}
+
+//installs the global tracer provider and the w3c trace context propagator.
+//the returned func flushes buffered spans and must be called on shutdown
+func initialiseTracing(ctx context.Context, conf *TracingConfig) (func(context.Context) error, error) {
+	//incoming traceparent headers are honoured even when not exporting,
+	//so request ids in the logs still line up with upstream traces
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if conf.Exporter == "" || conf.Exporter == TRACING_NONE {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newSpanExporter(ctx, conf)
+
+	if err != nil {
+		slog.Error("error creating trace exporter", "err", err.Error())
+		return nil, err
+	}
+
+	service_name := conf.Service_name
+
+	if service_name == "" {
+		service_name = DEFAULT_SERVICE_NAME
+	}
+
+	sample_ratio := conf.Sample_ratio
+
+	if sample_ratio == 0 {
+		sample_ratio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service_name))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sample_ratio))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	slog.Info("tracing enabled", "exporter", conf.Exporter, "sample_ratio", sample_ratio)
+
+	return provider.Shutdown, nil
+}
+
+//the outermost handler, starts the server span for each request from any
+//incoming traceparent header. the span is renamed to the route pattern
+//once the mux has matched it, see metricsMiddleware
+func tracingMiddleware(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.request")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//installs a tracer provider that keeps finished spans in memory
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	_, err := initialiseTracing(context.Background(), &TracingConfig{})
+
+	if err != nil {
+		t.Fatalf("error initialising tracing: %s", err.Error())
+	}
+
+	return recorder
+}
+
+func TestTracingSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	templates = initialiseTemplates()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /register", func(w http.ResponseWriter, r *http.Request) {
+		ctx, done := startDBCall(r.Context(), "GetUser")
+		done()
+
+		_, err := generatePageTemplate(ctx, "register.html", PageTemplate{})
+
+		if err != nil {
+			t.Errorf("error rendering template: %s", err.Error())
+		}
+	})
+
+	handler := tracingMiddleware(requestLoggingMiddleware(csrfMiddleware(metricsMiddleware(mux))))
+
+	req := httptest.NewRequest(http.MethodGet, "/register", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	server, ok := spans["GET /register"]
+
+	if !ok {
+		t.Fatalf("expected a server span named after the route, got %v", spans)
+	}
+
+	if server.SpanKind() != trace.SpanKindServer || server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Error("server span should continue the incoming w3c trace")
+	}
+
+	for _, name := range []string{ "db.GetUser", "template register.html" } {
+		span, ok := spans[name]
+
+		if !ok {
+			t.Errorf("expected a %s span", name)
+		} else if span.SpanContext().TraceID() != server.SpanContext().TraceID() {
+			t.Errorf("%s span should belong to the request trace", name)
+		}
+	}
+}
+
+func TestValidateTracingConfig(t *testing.T) {
+	valid := []TracingConfig{ {}, { Exporter: TRACING_STDOUT }, { Exporter: TRACING_OTLP, Sample_ratio: 0.5 } }
+
+	for _, conf := range valid {
+		if violations := validateTracingConfig(&conf); len(violations) != 0 {
+			t.Errorf("expected %+v to be valid, got %v", conf, violations)
+		}
+	}
+
+	invalid := []TracingConfig{ { Exporter: "zipkin" }, { Sample_ratio: 2 } }
+
+	for _, conf := range invalid {
+		if violations := validateTracingConfig(&conf); len(violations) == 0 {
+			t.Errorf("expected %+v to be invalid", conf)
+		}
+	}
+}